@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+
+	"github.com/segmentio/ksuid"
+)
+
+// maxUserAgentLength is the length the user agents are truncated to
+const maxUserAgentLength = 512
+
+// Types of the audited resources
+const (
+	ResourceCategory = "category"
+	ResourceUser     = "user"
+	ResourceApiKey   = "api_key"
+	ResourceSession  = "session"
+)
+
+// Event is a change a handler records in the audit log
+type Event struct {
+	// Action is what was done, like category.create
+	Action string
+
+	// ResourceType and ResourceId identify the changed resource
+	ResourceType string
+	ResourceId   string
+
+	// ActorId is the user that made the change when the request is not authenticated, like a login
+	ActorId string
+
+	// Before and After are the snapshots of the resource, they must not hold any secret
+	Before interface{}
+	After  interface{}
+}
+
+// Record is a function that records a change made by a request in the audit log. The actor is the
+// authenticated user of the request and the admin impersonating it, if any.
+// The change was already made, so an error is only logged.
+func Record(r *http.Request, repo repository.Repository, event Event) {
+	// define the audit event with the metadata of the request
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	auditEvent := &models.AuditEvent{
+		Action:       event.Action,
+		ResourceType: event.ResourceType,
+		ResourceId:   event.ResourceId,
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		IP:           middlewares.ClientIP(r),
+		UserAgent:    userAgent,
+	}
+
+	// set the actor
+	if claims, ok := middlewares.ClaimsFromContext(r.Context()); ok {
+		auditEvent.ActorId = optional(claims.UserId)
+		auditEvent.ApiKeyId = optional(claims.ApiKeyId)
+		if claims.Impersonated() {
+			auditEvent.ImpersonatorId = optional(claims.Actor.UserId)
+		}
+	} else {
+		auditEvent.ActorId = optional(event.ActorId)
+	}
+
+	// record the event
+	if err := Log(r.Context(), repo, auditEvent, event.Before, event.After); err != nil {
+		log.Println(err)
+	}
+}
+
+// Log is a function that appends an event to the audit log with the snapshots of the resource.
+// It is used for the changes that are not made by a request, like the erasures of the gdpr command.
+func Log(ctx context.Context, repo repository.Repository, event *models.AuditEvent, before, after interface{}) error {
+	// generate the id
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("error generating audit event id: %v", err)
+	}
+	event.Id = id.String()
+
+	// encode the snapshots
+	if event.Before, err = snapshot(before); err != nil {
+		return fmt.Errorf("error encoding snapshot of audit event %s: %v", event.Action, err)
+	}
+	if event.After, err = snapshot(after); err != nil {
+		return fmt.Errorf("error encoding snapshot of audit event %s: %v", event.Action, err)
+	}
+
+	// persist the event
+	return repo.InsertAuditEvent(ctx, event)
+}
+
+// snapshot is a function that encodes the snapshot of a resource, nil when there is no snapshot
+func snapshot(v interface{}) (json.RawMessage, error) {
+	// check if there is a snapshot
+	if v == nil {
+		return nil, nil
+	}
+
+	// encode the snapshot
+	return json.Marshal(v)
+}
+
+// optional is a function that returns nil for an empty id
+func optional(id string) *string {
+	if id == "" {
+		return nil
+	}
+	return &id
+}
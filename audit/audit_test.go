@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"context"
+	"net/http/httptest"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"strings"
+	"testing"
+)
+
+// auditRepository is a repository that keeps the inserted audit events
+type auditRepository struct {
+	repository.Repository
+	events []*models.AuditEvent
+}
+
+func (r *auditRepository) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+// value is a function that returns the value of an optional id, empty for nil
+func value(id *string) string {
+	if id == nil {
+		return ""
+	}
+	return *id
+}
+
+func TestRecordSetsTheActor(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       *models.AppClaims
+		eventActor   string
+		actor        string
+		apiKey       string
+		impersonator string
+	}{
+		{name: "anonymous request", actor: ""},
+		{name: "login of a user", eventActor: "user", actor: "user"},
+		{name: "authenticated user", claims: &models.AppClaims{UserId: "user"}, eventActor: "other", actor: "user"},
+		{name: "api key", claims: &models.AppClaims{UserId: "user", ApiKeyId: "key"}, actor: "user", apiKey: "key"},
+		{name: "impersonation", claims: &models.AppClaims{UserId: "user", Actor: &models.Actor{UserId: "admin"}}, actor: "user", impersonator: "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// build the request of the case
+			r := httptest.NewRequest("DELETE", "/categories/1", nil)
+			r.RemoteAddr = "203.0.113.7:4321"
+			if tt.claims != nil {
+				r = r.WithContext(middlewares.WithClaims(r.Context(), tt.claims))
+			}
+
+			// record the event
+			repo := &auditRepository{}
+			Record(r, repo, Event{Action: "category.delete", ResourceType: ResourceCategory, ResourceId: "1", ActorId: tt.eventActor})
+			if len(repo.events) != 1 {
+				t.Fatalf("got %d events, want 1", len(repo.events))
+			}
+			event := repo.events[0]
+
+			// check the actor and the metadata of the request
+			if value(event.ActorId) != tt.actor || value(event.ApiKeyId) != tt.apiKey || value(event.ImpersonatorId) != tt.impersonator {
+				t.Errorf("got actor %q, api key %q and impersonator %q, want %q, %q and %q",
+					value(event.ActorId), value(event.ApiKeyId), value(event.ImpersonatorId), tt.actor, tt.apiKey, tt.impersonator)
+			}
+			if event.Id == "" || event.Method != "DELETE" || event.Path != "/categories/1" || event.IP != "203.0.113.7" {
+				t.Errorf("unexpected metadata %+v", event)
+			}
+		})
+	}
+}
+
+func TestRecordEncodesTheSnapshots(t *testing.T) {
+	// record a change with a long user agent
+	r := httptest.NewRequest("PUT", "/categories/1", nil)
+	r.Header.Set("User-Agent", strings.Repeat("a", 2*maxUserAgentLength))
+	repo := &auditRepository{}
+	Record(r, repo, Event{
+		Action:       "category.update",
+		ResourceType: ResourceCategory,
+		ResourceId:   "1",
+		Before:       map[string]string{"name": "old"},
+		After:        map[string]string{"name": "new"},
+	})
+	if len(repo.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(repo.events))
+	}
+	event := repo.events[0]
+
+	// the snapshots are encoded and the user agent is truncated
+	if string(event.Before) != `{"name":"old"}` || string(event.After) != `{"name":"new"}` {
+		t.Errorf("got snapshots %s and %s", event.Before, event.After)
+	}
+	if len(event.UserAgent) != maxUserAgentLength {
+		t.Errorf("got user agent of %d bytes, want %d", len(event.UserAgent), maxUserAgentLength)
+	}
+
+	// a change without snapshots stores none
+	Record(r, repo, Event{Action: "category.delete", ResourceType: ResourceCategory, ResourceId: "1"})
+	if event := repo.events[1]; event.Before != nil || event.After != nil {
+		t.Errorf("got snapshots %s and %s, want none", event.Before, event.After)
+	}
+}
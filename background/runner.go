@@ -0,0 +1,63 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Runner runs the tasks that outlive the request that started them, like the emails sent after the response.
+// The server waits for the running tasks when it shuts down, before the repository and the mailer are closed.
+type Runner struct {
+	tasks   sync.WaitGroup
+	mutex   sync.Mutex
+	stopped bool
+}
+
+// NewRunner is a function that creates a new runner
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+// Go is a method that runs a task in its own goroutine. It returns false without running it
+// once the runner has been shut down.
+func (r *Runner) Go(task func()) bool {
+	// register the task, unless the runner is shutting down
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return false
+	}
+	r.tasks.Add(1)
+	r.mutex.Unlock()
+
+	// run the task
+	go func() {
+		defer r.tasks.Done()
+		task()
+	}()
+
+	// return the task was started
+	return true
+}
+
+// Shutdown stops accepting tasks and waits for the running ones to finish
+func (r *Runner) Shutdown(ctx context.Context) error {
+	// stop accepting tasks
+	r.mutex.Lock()
+	r.stopped = true
+	r.mutex.Unlock()
+
+	// wait for the running tasks
+	finished := make(chan struct{})
+	go func() {
+		r.tasks.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for background tasks: %v", ctx.Err())
+	}
+}
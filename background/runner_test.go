@@ -0,0 +1,53 @@
+package background
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForRunningTasks(t *testing.T) {
+	runner := NewRunner()
+
+	// start a slow task
+	started := make(chan struct{})
+	var finished atomic.Bool
+	if !runner.Go(func() {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	}) {
+		t.Fatal("task not started")
+	}
+	<-started
+
+	// the shutdown returns once the task is done
+	if err := runner.Shutdown(context.Background()); err != nil {
+		t.Fatalf("error shutting down: %v", err)
+	}
+	if !finished.Load() {
+		t.Fatal("shutdown returned before the task finished")
+	}
+
+	// no task runs after the shutdown
+	if runner.Go(func() { t.Error("task ran after shutdown") }) {
+		t.Fatal("task started after shutdown")
+	}
+}
+
+func TestShutdownIsBoundedByContext(t *testing.T) {
+	runner := NewRunner()
+
+	// start a task that outlives the deadline
+	release := make(chan struct{})
+	defer close(release)
+	runner.Go(func() { <-release })
+
+	// the shutdown gives up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := runner.Shutdown(ctx); err == nil {
+		t.Fatal("shutdown didn't report the running task")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/database/postgres"
+	"platzi/go/rest-ws/gdpr"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+
+	"github.com/joho/godotenv"
+)
+
+// usage is the help of the command
+const usage = `Usage:
+  gdpr export -user <id|email> [-out file]   write everything stored about a user as json
+  gdpr erase -user <id|email> -yes           delete a user and everything linked to it
+
+The database is read from DATABASE_URL, the .env file is loaded if it exists.
+`
+
+func main() {
+	// check the subcommand
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// define the flags of the subcommands
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	user := flags.String("user", "", "id or email of the user")
+	out := flags.String("out", "", "file of the export, the standard output if it is empty")
+	yes := flags.Bool("yes", false, "confirm the erasure")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(os.Args[2:])
+
+	// check the user
+	if *user == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Load .env file, the variables may come from the environment instead
+	if err := godotenv.Load(".env"); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	// init repository
+	repo, err := postgres.NewPostgresRepository(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+
+	// run the subcommand
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "export":
+		err = export(ctx, repo, *user, *out)
+	case "erase":
+		if !*yes {
+			log.Fatal("the erasure can't be undone, confirm it with -yes")
+		}
+		err = erase(ctx, repo, *user)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// check if there was an error
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// erase is a function that erases a user and records the erasure in the audit log
+func erase(ctx context.Context, repo repository.Repository, reference string) error {
+	// get the user, the audit log references it by id
+	user, err := gdpr.FindUser(ctx, repo, reference)
+	if err != nil {
+		return err
+	}
+
+	// erase the user
+	if err := gdpr.Erase(ctx, repo, user.Id); err != nil {
+		return err
+	}
+
+	// record the erasure
+	return audit.Log(ctx, repo, &models.AuditEvent{Action: "user.erase", ResourceType: audit.ResourceUser, ResourceId: user.Id}, nil, nil)
+}
+
+// export is a function that writes the export of a user to a file, or to the standard output
+func export(ctx context.Context, repo repository.Repository, user, out string) error {
+	// export the data of the user
+	data, err := gdpr.Export(ctx, repo, user)
+	if err != nil {
+		return err
+	}
+
+	// define the writer
+	var w io.Writer = os.Stdout
+	if out != "" {
+		// only the owner can read the file, it has personal data
+		file, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	// encode the export
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS refresh_tokens;
+
+CREATE TABLE refresh_tokens(
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
\ No newline at end of file
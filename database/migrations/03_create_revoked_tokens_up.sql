@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS revoked_tokens;
+
+CREATE TABLE revoked_tokens(
+    jti VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+DROP TABLE IF EXISTS user_token_revocations;
+
+CREATE TABLE user_token_revocations(
+    user_id VARCHAR(32) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before TIMESTAMP NOT NULL
+);
\ No newline at end of file
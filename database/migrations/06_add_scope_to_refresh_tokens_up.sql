@@ -0,0 +1 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
\ No newline at end of file
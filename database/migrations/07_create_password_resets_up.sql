@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS password_resets;
+
+CREATE TABLE password_resets(
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets(user_id);
\ No newline at end of file
@@ -0,0 +1,20 @@
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+DROP TABLE IF EXISTS user_mfa;
+
+CREATE TABLE user_mfa(
+    user_id VARCHAR(32) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    confirmed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfa_recovery_codes(
+    code_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX mfa_recovery_codes_user_id_idx ON mfa_recovery_codes(user_id);
\ No newline at end of file
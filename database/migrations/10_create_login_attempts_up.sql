@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS login_lockouts;
+
+DROP TABLE IF EXISTS login_attempts;
+
+CREATE TABLE login_attempts(
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    first_failure_at TIMESTAMP NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+CREATE TABLE login_lockouts(
+    id SERIAL PRIMARY KEY,
+    key VARCHAR(320) NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_until TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_lockouts_key_idx ON login_lockouts(key);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
\ No newline at end of file
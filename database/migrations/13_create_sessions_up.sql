@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS sessions;
+
+CREATE TABLE sessions(
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
\ No newline at end of file
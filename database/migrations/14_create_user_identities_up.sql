@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS user_identities;
+
+CREATE TABLE user_identities(
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+
+DROP TABLE IF EXISTS oidc_logins;
+
+CREATE TABLE oidc_logins(
+    state_hash VARCHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
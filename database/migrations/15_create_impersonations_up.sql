@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS impersonation_requests;
+
+DROP TABLE IF EXISTS impersonations;
+
+CREATE TABLE impersonations(
+    id VARCHAR(32) PRIMARY KEY,
+    admin_id VARCHAR(32) REFERENCES users(id) ON DELETE SET NULL,
+    user_id VARCHAR(32) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX impersonations_user_id_idx ON impersonations(user_id);
+CREATE INDEX impersonations_admin_id_idx ON impersonations(admin_id);
+
+CREATE TABLE impersonation_requests(
+    id BIGSERIAL PRIMARY KEY,
+    impersonation_id VARCHAR(32) NOT NULL REFERENCES impersonations(id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL,
+    path TEXT NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX impersonation_requests_impersonation_id_idx ON impersonation_requests(impersonation_id);
\ No newline at end of file
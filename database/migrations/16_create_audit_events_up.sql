@@ -0,0 +1,56 @@
+DROP TABLE IF EXISTS audit_events;
+
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+CREATE TABLE audit_events(
+    id VARCHAR(32) PRIMARY KEY,
+    actor_id VARCHAR(32),
+    api_key_id VARCHAR(32),
+    impersonator_id VARCHAR(32),
+    action VARCHAR(64) NOT NULL,
+    resource_type VARCHAR(32) NOT NULL,
+    resource_id VARCHAR(64) NOT NULL DEFAULT '',
+    before_snapshot JSONB,
+    after_snapshot JSONB,
+    method VARCHAR(16) NOT NULL DEFAULT '',
+    path TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit_events(created_at);
+CREATE INDEX audit_events_actor_id_idx ON audit_events(actor_id, created_at);
+CREATE INDEX audit_events_resource_idx ON audit_events(resource_type, resource_id, created_at);
+
+-- the events are append only, an update may only anonymize them by clearing the actor, the snapshots and the client
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'UPDATE' THEN
+        RAISE EXCEPTION 'audit events are append only';
+    END IF;
+    IF NEW.id IS DISTINCT FROM OLD.id
+        OR NEW.action IS DISTINCT FROM OLD.action
+        OR NEW.resource_type IS DISTINCT FROM OLD.resource_type
+        OR NEW.resource_id IS DISTINCT FROM OLD.resource_id
+        OR NEW.method IS DISTINCT FROM OLD.method
+        OR NEW.path IS DISTINCT FROM OLD.path
+        OR NEW.created_at IS DISTINCT FROM OLD.created_at
+        OR (NEW.actor_id IS DISTINCT FROM OLD.actor_id AND NEW.actor_id IS NOT NULL)
+        OR (NEW.api_key_id IS DISTINCT FROM OLD.api_key_id AND NEW.api_key_id IS NOT NULL)
+        OR (NEW.impersonator_id IS DISTINCT FROM OLD.impersonator_id AND NEW.impersonator_id IS NOT NULL)
+        OR (NEW.before_snapshot IS DISTINCT FROM OLD.before_snapshot AND NEW.before_snapshot IS NOT NULL)
+        OR (NEW.after_snapshot IS DISTINCT FROM OLD.after_snapshot AND NEW.after_snapshot IS NOT NULL)
+        OR (NEW.ip IS DISTINCT FROM OLD.ip AND NEW.ip <> '')
+        OR (NEW.user_agent IS DISTINCT FROM OLD.user_agent AND NEW.user_agent <> '') THEN
+        RAISE EXCEPTION 'audit events can only be anonymized';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only();
\ No newline at end of file
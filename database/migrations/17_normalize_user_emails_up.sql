@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS users_email_lower_key;
+
+-- the emails are stored normalized since the sign up validation, the older ones may have upper case letters
+-- or spaces. Two accounts that only differ in case make the update fail, they must be merged by hand first.
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX users_email_lower_key ON users(lower(email));
\ No newline at end of file
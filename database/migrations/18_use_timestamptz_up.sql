@@ -0,0 +1,74 @@
+-- the times were stored without time zone, lib/pq drops the offset of the go times so they drifted from NOW()
+-- on the hosts that don't run in UTC. The stored values are read in the time zone of the session, run this
+-- migration with the time zone the server had (SET TIME ZONE) if it was not the one of the database.
+ALTER TABLE refresh_tokens
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN used_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE revoked_tokens
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE user_token_revocations
+    ALTER COLUMN revoked_before TYPE TIMESTAMPTZ;
+
+ALTER TABLE api_keys
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN last_used_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE password_resets
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN used_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE email_verifications
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN used_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE user_mfa
+    ALTER COLUMN confirmed_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE mfa_recovery_codes
+    ALTER COLUMN used_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE login_attempts
+    ALTER COLUMN first_failure_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN last_failure_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN locked_until TYPE TIMESTAMPTZ;
+
+ALTER TABLE login_lockouts
+    ALTER COLUMN locked_until TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE users
+    ALTER COLUMN deletion_scheduled_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN disabled_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE sessions
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN last_seen_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN revoked_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE user_identities
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE oidc_logins
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE impersonations
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE impersonation_requests
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+ALTER TABLE audit_events
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
\ No newline at end of file
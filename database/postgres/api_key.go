@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// InsertApiKey is a method that inserts an api key into the database
+func (r *PostgresRepository) InsertApiKey(ctx context.Context, key *models.ApiKey) error {
+	// define the query
+	query := `INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, key.Id, key.UserId, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting api key at InsertApiKey: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetApiKeyByHash is a method that returns an api key by its hash, it returns nil if it doesn't exist
+func (r *PostgresRepository) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	// define the query
+	query := `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE key_hash = $1`
+
+	// scan the row into the key
+	key, err := scanApiKey(r.db.QueryRowContext(ctx, query, hash))
+
+	// check if the key doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting api key at GetApiKeyByHash: %v", err)
+	}
+
+	// return the key
+	return key, nil
+}
+
+// ListApiKeysByUser is a method that returns the api keys of a user, newest first
+func (r *PostgresRepository) ListApiKeysByUser(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	// define the query
+	query := `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`
+
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting api keys at ListApiKeysByUser: %v", err)
+	}
+
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at ListApiKeysByUser: %v", err)
+		}
+	}()
+
+	// define the keys
+	keys := make([]*models.ApiKey, 0)
+
+	// iterate over the rows
+	for rows.Next() {
+		// scan the row into the key
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning api key row at ListApiKeysByUser: %v", err)
+		}
+
+		// append the key to the list of keys
+		keys = append(keys, key)
+	}
+
+	// check if there was an error iterating over the rows
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	// return the keys
+	return keys, nil
+}
+
+// RevokeApiKey is a method that revokes an api key of a user, it returns false if the user has no such active key
+func (r *PostgresRepository) RevokeApiKey(ctx context.Context, userId, id string) (bool, error) {
+	// define the query
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return false, fmt.Errorf("error revoking api key at RevokeApiKey: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at RevokeApiKey: %v", err)
+	}
+
+	// return true if the key was revoked
+	return rowsAffected == 1, nil
+}
+
+// TouchApiKey is a method that records the last time an api key was used
+func (r *PostgresRepository) TouchApiKey(ctx context.Context, id string, usedAt time.Time) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, usedAt, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error updating api key at TouchApiKey: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// scanner is the interface shared by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanApiKey is a function that scans an api key from a row
+func scanApiKey(row scanner) (*models.ApiKey, error) {
+	// define the key
+	var key = models.ApiKey{}
+
+	// scan the row into the key
+	err := row.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// return the key
+	return &key, nil
+}
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"strconv"
+	"strings"
+)
+
+// auditEventColumns are the columns of the audit events that are scanned by scanAuditEvent
+const auditEventColumns = "id, actor_id, api_key_id, impersonator_id, action, resource_type, resource_id, before_snapshot, after_snapshot, method, path, ip, user_agent, created_at"
+
+// scanAuditEvent is a function that scans an audit event from a row
+func scanAuditEvent(row scanner) (*models.AuditEvent, error) {
+	// define the event and its snapshots, they are null when there is no snapshot
+	var event = models.AuditEvent{}
+	var before, after []byte
+
+	// scan the row into the event
+	err := row.Scan(&event.Id, &event.ActorId, &event.ApiKeyId, &event.ImpersonatorId, &event.Action, &event.ResourceType, &event.ResourceId,
+		&before, &after, &event.Method, &event.Path, &event.IP, &event.UserAgent, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// set the snapshots
+	if len(before) > 0 {
+		event.Before = json.RawMessage(before)
+	}
+	if len(after) > 0 {
+		event.After = json.RawMessage(after)
+	}
+
+	// return the event
+	return &event, nil
+}
+
+// nullJSON is a function that returns the value of a snapshot column, nil when there is no snapshot
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// InsertAuditEvent is a method that appends an event to the audit log
+func (r *PostgresRepository) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	// define the query
+	query := `INSERT INTO audit_events (id, actor_id, api_key_id, impersonator_id, action, resource_type, resource_id, before_snapshot, after_snapshot, method, path, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, event.Id, event.ActorId, event.ApiKeyId, event.ImpersonatorId, event.Action, event.ResourceType, event.ResourceId,
+		nullJSON(event.Before), nullJSON(event.After), event.Method, event.Path, event.IP, event.UserAgent)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting audit event at InsertAuditEvent: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ListAuditEvents is a method that lists the audit events that match the filter, the most recent first
+func (r *PostgresRepository) ListAuditEvents(ctx context.Context, filter models.AuditFilter, page, rowsPerPage int64) ([]*models.AuditEvent, int64, error) {
+	// define the conditions of the filter
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if filter.ActorId != "" {
+		where("actor_id = ?", filter.ActorId)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceId != "" {
+		where("resource_id = ?", filter.ResourceId)
+	}
+	if filter.From != nil {
+		where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < ?", *filter.To)
+	}
+
+	// join the conditions
+	clause := ""
+	if len(conditions) > 0 {
+		clause = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// define the query
+	query := "SELECT " + auditEventColumns + " FROM audit_events" + clause +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, query, append(args, rowsPerPage, (page-1)*rowsPerPage)...)
+
+	// check if there was an error
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting audit events at ListAuditEvents: %v", err)
+	}
+
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at ListAuditEvents: %v", err)
+		}
+	}()
+
+	// define the events
+	events := make([]*models.AuditEvent, 0)
+
+	// iterate over the rows
+	for rows.Next() {
+		// scan the row into the event
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning audit event row at ListAuditEvents: %v", err)
+		}
+
+		// append the event to the list of events
+		events = append(events, event)
+	}
+
+	// check if there was an error iterating over the rows
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	// define the total number of events
+	var total int64
+
+	// count the events that match the filter
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events"+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error scanning total row at ListAuditEvents: %v", err)
+	}
+
+	// return the events and the total
+	return events, total, nil
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+)
+
+// InsertEmailVerification is a method that inserts an email verification into the database
+func (r *PostgresRepository) InsertEmailVerification(ctx context.Context, verification *models.EmailVerification) error {
+	// define the query
+	query := `INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, verification.TokenHash, verification.UserId, verification.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting email verification at InsertEmailVerification: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetEmailVerificationByHash is a method that returns an email verification by the hash of its token,
+// it returns nil if it doesn't exist
+func (r *PostgresRepository) GetEmailVerificationByHash(ctx context.Context, hash string) (*models.EmailVerification, error) {
+	// define the query
+	query := `SELECT token_hash, user_id, expires_at, used_at, created_at FROM email_verifications WHERE token_hash = $1`
+
+	// scan the row into the verification
+	verification, err := scanEmailVerification(r.db.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		return nil, fmt.Errorf("error getting email verification at GetEmailVerificationByHash: %v", err)
+	}
+
+	// return the verification
+	return verification, nil
+}
+
+// GetLatestEmailVerification is a method that returns the last email verification sent to a user,
+// it returns nil if none was sent
+func (r *PostgresRepository) GetLatestEmailVerification(ctx context.Context, userId string) (*models.EmailVerification, error) {
+	// define the query
+	query := `SELECT token_hash, user_id, expires_at, used_at, created_at FROM email_verifications WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+
+	// scan the row into the verification
+	verification, err := scanEmailVerification(r.db.QueryRowContext(ctx, query, userId))
+	if err != nil {
+		return nil, fmt.Errorf("error getting email verification at GetLatestEmailVerification: %v", err)
+	}
+
+	// return the verification
+	return verification, nil
+}
+
+// UseEmailVerification is a method that marks an email verification as used.
+// It returns false if it was already used or expired.
+func (r *PostgresRepository) UseEmailVerification(ctx context.Context, hash string) (bool, error) {
+	// define the query, the condition makes concurrent uses of the same token fail
+	query := `UPDATE email_verifications SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, hash)
+	if err != nil {
+		return false, fmt.Errorf("error using email verification at UseEmailVerification: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at UseEmailVerification: %v", err)
+	}
+
+	// return true if the verification was marked
+	return rowsAffected == 1, nil
+}
+
+// scanEmailVerification is a function that scans an email verification from a row, it returns nil if there is no row
+func scanEmailVerification(row *sql.Row) (*models.EmailVerification, error) {
+	// define the verification
+	var verification = models.EmailVerification{}
+
+	// scan the row into the verification
+	err := row.Scan(&verification.TokenHash, &verification.UserId, &verification.ExpiresAt, &verification.UsedAt, &verification.CreatedAt)
+
+	// check if there is no row
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, err
+	}
+
+	// return the verification
+	return &verification, nil
+}
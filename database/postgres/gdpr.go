@@ -0,0 +1,259 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// queryRows is a function that runs a query in a transaction and scans every row with the scan function
+func queryRows(ctx context.Context, tx *sql.Tx, query string, args []interface{}, scan func(row scanner) error) error {
+	// execute the query
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// scan every row
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	// return the error of the iteration, if any
+	return rows.Err()
+}
+
+// ExportUserData is a method that reads the rows of every table linked to a user, in a read only transaction
+// so the export is consistent. The keys are the keys of the login attempts of the user.
+func (r *PostgresRepository) ExportUserData(ctx context.Context, userId string, attemptKeys []string) (*models.UserExport, error) {
+	// begin the transaction, every query sees the same snapshot
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction at ExportUserData: %v", err)
+	}
+	defer tx.Rollback()
+
+	// define the export
+	export := &models.UserExport{
+		ExportedAt:         time.Now(),
+		Identities:         make([]*models.UserIdentity, 0),
+		Sessions:           make([]*models.Session, 0),
+		RefreshTokens:      make([]*models.RefreshToken, 0),
+		RevokedTokens:      make([]*models.RevokedToken, 0),
+		ApiKeys:            make([]*models.ApiKey, 0),
+		PasswordResets:     make([]*models.PasswordReset, 0),
+		EmailVerifications: make([]*models.EmailVerification, 0),
+		RecoveryCodes:      make([]*models.RecoveryCode, 0),
+		LoginAttempts:      make([]*models.LoginAttempts, 0),
+		LoginLockouts:      make([]*models.LoginLockout, 0),
+		Impersonations:     make([]*models.Impersonation, 0),
+		AuditEvents:        make([]*models.AuditEvent, 0),
+	}
+
+	// get the user
+	var user = models.User{}
+	err = tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userId).Scan(userDestinations(&user)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting user at ExportUserData: %v", err)
+	}
+	export.User = models.NewExportedUser(&user)
+
+	// define the queries of the linked tables
+	args := []interface{}{userId}
+	queries := []struct {
+		table string
+		query string
+		args  []interface{}
+		scan  func(row scanner) error
+	}{
+		{"user_identities", `SELECT issuer, subject, user_id, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var i models.UserIdentity
+			export.Identities = append(export.Identities, &i)
+			return row.Scan(&i.Issuer, &i.Subject, &i.UserId, &i.Email, &i.CreatedAt)
+		}},
+		{"sessions", "SELECT " + sessionColumns + " FROM sessions WHERE user_id = $1 ORDER BY created_at", args, func(row scanner) error {
+			session, err := scanSession(row)
+			export.Sessions = append(export.Sessions, session)
+			return err
+		}},
+		{"refresh_tokens", `SELECT id, user_id, family_id, scope, expires_at, used_at, revoked_at, created_at FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var t models.RefreshToken
+			export.RefreshTokens = append(export.RefreshTokens, &t)
+			return row.Scan(&t.Id, &t.UserId, &t.FamilyId, &t.Scope, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt, &t.CreatedAt)
+		}},
+		{"revoked_tokens", `SELECT jti, user_id, expires_at, revoked_at FROM revoked_tokens WHERE user_id = $1 ORDER BY revoked_at`, args, func(row scanner) error {
+			var t models.RevokedToken
+			export.RevokedTokens = append(export.RevokedTokens, &t)
+			return row.Scan(&t.Jti, &t.UserId, &t.ExpiresAt, &t.RevokedAt)
+		}},
+		{"user_token_revocations", `SELECT revoked_before FROM user_token_revocations WHERE user_id = $1`, args, func(row scanner) error {
+			return row.Scan(&export.TokensRevokedAt)
+		}},
+		{"api_keys", `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			key, err := scanApiKey(row)
+			export.ApiKeys = append(export.ApiKeys, key)
+			return err
+		}},
+		{"password_resets", `SELECT user_id, expires_at, used_at, created_at FROM password_resets WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var p models.PasswordReset
+			export.PasswordResets = append(export.PasswordResets, &p)
+			return row.Scan(&p.UserId, &p.ExpiresAt, &p.UsedAt, &p.CreatedAt)
+		}},
+		{"email_verifications", `SELECT user_id, expires_at, used_at, created_at FROM email_verifications WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var v models.EmailVerification
+			export.EmailVerifications = append(export.EmailVerifications, &v)
+			return row.Scan(&v.UserId, &v.ExpiresAt, &v.UsedAt, &v.CreatedAt)
+		}},
+		{"user_mfa", `SELECT user_id, confirmed_at, created_at FROM user_mfa WHERE user_id = $1`, args, func(row scanner) error {
+			export.MFA = &models.UserMFA{}
+			return row.Scan(&export.MFA.UserId, &export.MFA.ConfirmedAt, &export.MFA.CreatedAt)
+		}},
+		{"mfa_recovery_codes", `SELECT user_id, used_at, created_at FROM mfa_recovery_codes WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var c models.RecoveryCode
+			export.RecoveryCodes = append(export.RecoveryCodes, &c)
+			return row.Scan(&c.UserId, &c.UsedAt, &c.CreatedAt)
+		}},
+		{"login_attempts", `SELECT key, failures, first_failure_at, last_failure_at, locked_until FROM login_attempts WHERE key = ANY($1)`, []interface{}{pq.Array(attemptKeys)}, func(row scanner) error {
+			var a models.LoginAttempts
+			export.LoginAttempts = append(export.LoginAttempts, &a)
+			return row.Scan(&a.Key, &a.Failures, &a.FirstFailureAt, &a.LastFailureAt, &a.LockedUntil)
+		}},
+		{"login_lockouts", `SELECT id, key, failures, locked_until, created_at FROM login_lockouts WHERE key = ANY($1) ORDER BY created_at`, []interface{}{pq.Array(attemptKeys)}, func(row scanner) error {
+			var l models.LoginLockout
+			export.LoginLockouts = append(export.LoginLockouts, &l)
+			return row.Scan(&l.Id, &l.Key, &l.Failures, &l.LockedUntil, &l.CreatedAt)
+		}},
+		{"impersonations", `SELECT id, admin_id, user_id, reason, expires_at, created_at FROM impersonations WHERE user_id = $1 ORDER BY created_at`, args, func(row scanner) error {
+			var i models.Impersonation
+			export.Impersonations = append(export.Impersonations, &i)
+			return row.Scan(&i.Id, &i.AdminId, &i.UserId, &i.Reason, &i.ExpiresAt, &i.CreatedAt)
+		}},
+		{"audit_events", "SELECT " + auditEventColumns + " FROM audit_events WHERE actor_id = $1 OR (resource_type = 'user' AND resource_id = $1) ORDER BY created_at", args, func(row scanner) error {
+			event, err := scanAuditEvent(row)
+			export.AuditEvents = append(export.AuditEvents, event)
+			return err
+		}},
+	}
+
+	// read every table
+	for _, q := range queries {
+		if err := queryRows(ctx, tx, q.query, q.args, q.scan); err != nil {
+			return nil, fmt.Errorf("error exporting %s at ExportUserData: %v", q.table, err)
+		}
+	}
+
+	// return the export
+	return export, nil
+}
+
+// EraseUser is a method that deletes a user and every row linked to it in one transaction.
+// The tables are emptied explicitly, so the erasure doesn't depend on the foreign keys. The audit log is
+// append only, so its events are anonymized instead. It returns false if the user doesn't exist.
+func (r *PostgresRepository) EraseUser(ctx context.Context, userId string, attemptKeys []string) (bool, error) {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction at EraseUser: %v", err)
+	}
+	defer tx.Rollback()
+
+	// lock the user, so nothing new is linked to it meanwhile
+	var id string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error locking user at EraseUser: %v", err)
+	}
+
+	// define the statements, the user goes last
+	statements := []struct {
+		table string
+		query string
+		arg   interface{}
+	}{
+		{"user_identities", "DELETE FROM user_identities WHERE user_id = $1", userId},
+		{"sessions", "DELETE FROM sessions WHERE user_id = $1", userId},
+		{"refresh_tokens", "DELETE FROM refresh_tokens WHERE user_id = $1", userId},
+		{"revoked_tokens", "DELETE FROM revoked_tokens WHERE user_id = $1", userId},
+		{"user_token_revocations", "DELETE FROM user_token_revocations WHERE user_id = $1", userId},
+		{"api_keys", "DELETE FROM api_keys WHERE user_id = $1", userId},
+		{"password_resets", "DELETE FROM password_resets WHERE user_id = $1", userId},
+		{"email_verifications", "DELETE FROM email_verifications WHERE user_id = $1", userId},
+		{"mfa_recovery_codes", "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId},
+		{"user_mfa", "DELETE FROM user_mfa WHERE user_id = $1", userId},
+		{"login_attempts", "DELETE FROM login_attempts WHERE key = ANY($1)", pq.Array(attemptKeys)},
+		{"login_lockouts", "DELETE FROM login_lockouts WHERE key = ANY($1)", pq.Array(attemptKeys)},
+		{"impersonation_requests", "DELETE FROM impersonation_requests WHERE impersonation_id IN (SELECT id FROM impersonations WHERE user_id = $1)", userId},
+		{"impersonations", "DELETE FROM impersonations WHERE user_id = $1", userId},
+		{"impersonations", "UPDATE impersonations SET admin_id = NULL WHERE admin_id = $1", userId},
+		{"audit_events", "UPDATE audit_events SET actor_id = NULL, api_key_id = NULL, ip = '', user_agent = '' WHERE actor_id = $1", userId},
+		{"audit_events", "UPDATE audit_events SET impersonator_id = NULL WHERE impersonator_id = $1", userId},
+		{"audit_events", "UPDATE audit_events SET before_snapshot = NULL, after_snapshot = NULL WHERE resource_type = 'user' AND resource_id = $1", userId},
+		{"users", "DELETE FROM users WHERE id = $1", userId},
+	}
+
+	// execute every statement
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.arg); err != nil {
+			return false, fmt.Errorf("error erasing %s at EraseUser: %v", statement.table, err)
+		}
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction at EraseUser: %v", err)
+	}
+
+	// return true as the user was erased
+	return true, nil
+}
+
+// ListUsersScheduledForDeletion is a method that returns the ids of the users whose deletion is scheduled before a time
+func (r *PostgresRepository) ListUsersScheduledForDeletion(ctx context.Context, before time.Time) ([]string, error) {
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, "SELECT id FROM users WHERE deletion_scheduled_at <= $1 ORDER BY deletion_scheduled_at", before)
+	if err != nil {
+		return nil, fmt.Errorf("error getting users at ListUsersScheduledForDeletion: %v", err)
+	}
+
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at ListUsersScheduledForDeletion: %v", err)
+		}
+	}()
+
+	// define the ids
+	ids := make([]string, 0)
+
+	// iterate over the rows
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning user row at ListUsersScheduledForDeletion: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// check if there was an error iterating over the rows
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	// return the ids
+	return ids, nil
+}
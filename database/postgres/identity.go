@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+
+	"github.com/lib/pq"
+)
+
+// GetUserIdentity is a method that returns the identity of a provider account, it returns nil if it isn't linked
+func (r *PostgresRepository) GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	// define the query
+	query := `SELECT issuer, subject, user_id, email, created_at FROM user_identities WHERE issuer = $1 AND subject = $2`
+
+	// scan the row into the identity
+	var identity = models.UserIdentity{}
+	err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(&identity.Issuer, &identity.Subject, &identity.UserId, &identity.Email, &identity.CreatedAt)
+
+	// check if the identity isn't linked
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting user identity at GetUserIdentity: %v", err)
+	}
+
+	// return the identity
+	return &identity, nil
+}
+
+// InsertUserIdentity is a method that links a provider account to an existing user
+func (r *PostgresRepository) InsertUserIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, $4)", identity.Issuer, identity.Subject, identity.UserId, identity.Email)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting user identity at InsertUserIdentity: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// InsertUserWithIdentity is a method that creates a user for a provider account and links them in one transaction
+func (r *PostgresRepository) InsertUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction at InsertUserWithIdentity: %v", err)
+	}
+	defer tx.Rollback()
+
+	// insert the user, the provider may have verified its email already
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (id, email, password, role, email_verified, display_name) VALUES ($1, $2, $3, $4, $5, $6)",
+		user.Id, user.Email, user.Password, user.Role, user.EmailVerified, user.DisplayName)
+
+	// check if the email is already in use
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == "users_email_key" {
+		return fmt.Errorf("error inserting user at InsertUserWithIdentity: %w", repository.ErrDuplicateEmail)
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting user at InsertUserWithIdentity: %v", err)
+	}
+
+	// link the identity
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, $4)", identity.Issuer, identity.Subject, user.Id, identity.Email)
+	if err != nil {
+		return fmt.Errorf("error inserting user identity at InsertUserWithIdentity: %v", err)
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction at InsertUserWithIdentity: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// InsertOIDCLogin is a method that stores a login started at the provider.
+// The logins that expired without a callback are removed at the same time.
+func (r *PostgresRepository) InsertOIDCLogin(ctx context.Context, login *models.OIDCLogin) error {
+	// remove the expired logins
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM oidc_logins WHERE expires_at < NOW()"); err != nil {
+		return fmt.Errorf("error deleting expired oidc logins at InsertOIDCLogin: %v", err)
+	}
+
+	// define the query
+	query := `INSERT INTO oidc_logins (state_hash, nonce, code_verifier, scope, expires_at) VALUES ($1, $2, $3, $4, $5)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, login.StateHash, login.Nonce, login.CodeVerifier, login.Scope, login.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting oidc login at InsertOIDCLogin: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ConsumeOIDCLogin is a method that removes and returns a login by the hash of its state, so each state
+// is only used once. It returns nil if it doesn't exist.
+func (r *PostgresRepository) ConsumeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error) {
+	// define the query
+	query := `DELETE FROM oidc_logins WHERE state_hash = $1 RETURNING state_hash, nonce, code_verifier, scope, expires_at, created_at`
+
+	// scan the row into the login
+	var login = models.OIDCLogin{}
+	err := r.db.QueryRowContext(ctx, query, stateHash).Scan(&login.StateHash, &login.Nonce, &login.CodeVerifier, &login.Scope, &login.ExpiresAt, &login.CreatedAt)
+
+	// check if the login doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error consuming oidc login at ConsumeOIDCLogin: %v", err)
+	}
+
+	// return the login
+	return &login, nil
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"platzi/go/rest-ws/models"
+)
+
+// InsertImpersonation is a method that records the token an admin was given to act as another user
+func (r *PostgresRepository) InsertImpersonation(ctx context.Context, impersonation *models.Impersonation) error {
+	// define the query
+	query := `INSERT INTO impersonations (id, admin_id, user_id, reason, expires_at) VALUES ($1, $2, $3, $4, $5)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, impersonation.Id, impersonation.AdminId, impersonation.UserId, impersonation.Reason, impersonation.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting impersonation at InsertImpersonation: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// InsertImpersonationRequest is a method that records a request made with an impersonation token
+func (r *PostgresRepository) InsertImpersonationRequest(ctx context.Context, request *models.ImpersonationRequest) error {
+	// define the query
+	query := `INSERT INTO impersonation_requests (impersonation_id, method, path, ip) VALUES ($1, $2, $3, $4)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, request.ImpersonationId, request.Method, request.Path, request.IP)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting impersonation request at InsertImpersonationRequest: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
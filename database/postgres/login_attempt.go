@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"time"
+)
+
+// GetLoginAttempts is a method that returns the failed logins of a key, it returns nil if it has none
+func (r *PostgresRepository) GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	// define the query
+	query := `SELECT key, failures, first_failure_at, last_failure_at, locked_until FROM login_attempts WHERE key = $1`
+
+	// define the attempts
+	var attempts = models.LoginAttempts{}
+
+	// scan the row into the attempts
+	err := r.db.QueryRowContext(ctx, query, key).Scan(&attempts.Key, &attempts.Failures, &attempts.FirstFailureAt, &attempts.LastFailureAt, &attempts.LockedUntil)
+
+	// check if the key has no attempts
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting login attempts at GetLoginAttempts: %v", err)
+	}
+
+	// return the attempts
+	return &attempts, nil
+}
+
+// RecordLoginFailure is a method that counts a failed login of a key in a single statement,
+// restarting the count if the first failure is before the start of the window
+func (r *PostgresRepository) RecordLoginFailure(ctx context.Context, key string, now, windowStart time.Time) (*models.LoginAttempts, error) {
+	// define the query
+	query := `INSERT INTO login_attempts (key, failures, first_failure_at, last_failure_at) VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.first_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			first_failure_at = CASE WHEN login_attempts.first_failure_at < $3 THEN $2 ELSE login_attempts.first_failure_at END,
+			last_failure_at = $2
+		RETURNING key, failures, first_failure_at, last_failure_at, locked_until`
+
+	// define the attempts
+	var attempts = models.LoginAttempts{}
+
+	// execute the query and scan the row into the attempts
+	err := r.db.QueryRowContext(ctx, query, key, now, windowStart).Scan(&attempts.Key, &attempts.Failures, &attempts.FirstFailureAt, &attempts.LastFailureAt, &attempts.LockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("error recording login failure at RecordLoginFailure: %v", err)
+	}
+
+	// return the attempts
+	return &attempts, nil
+}
+
+// LockLogin is a method that locks a key out and records the lockout in a transaction
+func (r *PostgresRepository) LockLogin(ctx context.Context, lockout *models.LoginLockout) error {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction at LockLogin: %v", err)
+	}
+	defer tx.Rollback()
+
+	// lock the key
+	_, err = tx.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`, lockout.Key, lockout.LockedUntil)
+	if err != nil {
+		return fmt.Errorf("error locking login at LockLogin: %v", err)
+	}
+
+	// record the lockout
+	query := `INSERT INTO login_lockouts (key, failures, locked_until) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, lockout.Key, lockout.Failures, lockout.LockedUntil).Scan(&lockout.Id, &lockout.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting login lockout at LockLogin: %v", err)
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction at LockLogin: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ResetLoginAttempts is a method that removes the failed logins of a key
+func (r *PostgresRepository) ResetLoginAttempts(ctx context.Context, key string) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error resetting login attempts at ResetLoginAttempts: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// DeleteStaleLoginAttempts is a method that removes the failed logins whose last failure is before a time
+// and that are not locked out
+func (r *PostgresRepository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	// define the query
+	query := `DELETE FROM login_attempts WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, before, time.Now())
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error deleting stale login attempts at DeleteStaleLoginAttempts: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+)
+
+// UpsertUserMFA is a method that stores the TOTP secret of a user while it is not confirmed.
+// Enrolling again replaces the pending secret, a confirmed one is never replaced.
+func (r *PostgresRepository) UpsertUserMFA(ctx context.Context, mfa *models.UserMFA) (bool, error) {
+	// define the query
+	query := `INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.confirmed_at IS NULL`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, mfa.UserId, mfa.Secret)
+	if err != nil {
+		return false, fmt.Errorf("error storing mfa at UpsertUserMFA: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at UpsertUserMFA: %v", err)
+	}
+
+	// return true if the secret was stored
+	return rowsAffected == 1, nil
+}
+
+// GetUserMFA is a method that returns the TOTP second factor of a user, it returns nil if the user has not enrolled
+func (r *PostgresRepository) GetUserMFA(ctx context.Context, userId string) (*models.UserMFA, error) {
+	// define the query
+	query := `SELECT user_id, secret, last_used_step, confirmed_at, created_at FROM user_mfa WHERE user_id = $1`
+
+	// define the mfa
+	var mfa = models.UserMFA{}
+
+	// scan the row into the mfa
+	err := r.db.QueryRowContext(ctx, query, userId).Scan(&mfa.UserId, &mfa.Secret, &mfa.LastUsedStep, &mfa.ConfirmedAt, &mfa.CreatedAt)
+
+	// check if the mfa doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting mfa at GetUserMFA: %v", err)
+	}
+
+	// return the mfa
+	return &mfa, nil
+}
+
+// ConfirmUserMFA is a method that enables the second factor of a user and stores its recovery codes in a transaction.
+// It returns false if the second factor was already confirmed or the code step was already used.
+func (r *PostgresRepository) ConfirmUserMFA(ctx context.Context, userId string, step int64, codeHashes []string) (bool, error) {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction at ConfirmUserMFA: %v", err)
+	}
+	defer tx.Rollback()
+
+	// confirm the mfa
+	query := `UPDATE user_mfa SET confirmed_at = NOW(), last_used_step = $2 WHERE user_id = $1 AND confirmed_at IS NULL AND last_used_step < $2`
+	result, err := tx.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		return false, fmt.Errorf("error confirming mfa at ConfirmUserMFA: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at ConfirmUserMFA: %v", err)
+	}
+	if rowsAffected != 1 {
+		return false, nil
+	}
+
+	// replace the recovery codes
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return false, fmt.Errorf("error replacing recovery codes at ConfirmUserMFA: %v", err)
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction at ConfirmUserMFA: %v", err)
+	}
+
+	// return true as the mfa was confirmed
+	return true, nil
+}
+
+// UseTOTPStep is a method that records the time step of an accepted code.
+// It returns false if that step or a later one was already used, so a code can't be replayed.
+func (r *PostgresRepository) UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error) {
+	// define the query
+	query := `UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, userId, step)
+	if err != nil {
+		return false, fmt.Errorf("error using totp step at UseTOTPStep: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at UseTOTPStep: %v", err)
+	}
+
+	// return true if the step was recorded
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode is a method that marks a recovery code of a user as used, it returns false if it doesn't exist or was used
+func (r *PostgresRepository) UseRecoveryCode(ctx context.Context, userId, hash string) (bool, error) {
+	// define the query
+	query := `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, hash, userId)
+	if err != nil {
+		return false, fmt.Errorf("error using recovery code at UseRecoveryCode: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at UseRecoveryCode: %v", err)
+	}
+
+	// return true if the code was marked
+	return rowsAffected == 1, nil
+}
+
+// replaceRecoveryCodes is a function that replaces the recovery codes of a user within a transaction
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId string, codeHashes []string) error {
+	// delete the old codes
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %v", err)
+	}
+
+	// insert the new codes
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (code_hash, user_id) VALUES ($1, $2)`, hash, userId); err != nil {
+			return fmt.Errorf("error inserting recovery code: %v", err)
+		}
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ReplaceRecoveryCodes is a method that replaces the recovery codes of a user in a transaction.
+// It returns false if the second factor of the user is not enabled.
+func (r *PostgresRepository) ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) (bool, error) {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction at ReplaceRecoveryCodes: %v", err)
+	}
+	defer tx.Rollback()
+
+	// lock the enabled mfa, so it can't be disabled while the codes are replaced
+	var confirmed bool
+	err = tx.QueryRowContext(ctx, `SELECT TRUE FROM user_mfa WHERE user_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE`, userId).Scan(&confirmed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting mfa at ReplaceRecoveryCodes: %v", err)
+	}
+
+	// replace the recovery codes
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return false, fmt.Errorf("error replacing recovery codes at ReplaceRecoveryCodes: %v", err)
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction at ReplaceRecoveryCodes: %v", err)
+	}
+
+	// return true as the codes were replaced
+	return true, nil
+}
+
+// DeleteUserMFA is a method that removes the second factor of a user and its recovery codes in a transaction.
+// It returns false if the user has not enrolled.
+func (r *PostgresRepository) DeleteUserMFA(ctx context.Context, userId string) (bool, error) {
+	// begin the transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction at DeleteUserMFA: %v", err)
+	}
+	defer tx.Rollback()
+
+	// delete the recovery codes
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return false, fmt.Errorf("error deleting recovery codes at DeleteUserMFA: %v", err)
+	}
+
+	// delete the mfa
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userId)
+	if err != nil {
+		return false, fmt.Errorf("error deleting mfa at DeleteUserMFA: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at DeleteUserMFA: %v", err)
+	}
+
+	// commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction at DeleteUserMFA: %v", err)
+	}
+
+	// return true if the mfa was deleted
+	return rowsAffected == 1, nil
+}
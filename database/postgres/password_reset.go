@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+)
+
+// InsertPasswordReset is a method that inserts a password reset into the database
+func (r *PostgresRepository) InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	// define the query
+	query := `INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, reset.TokenHash, reset.UserId, reset.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting password reset at InsertPasswordReset: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetPasswordResetByHash is a method that returns a password reset by the hash of its token, it returns nil if it doesn't exist
+func (r *PostgresRepository) GetPasswordResetByHash(ctx context.Context, hash string) (*models.PasswordReset, error) {
+	// define the query
+	query := `SELECT token_hash, user_id, expires_at, used_at, created_at FROM password_resets WHERE token_hash = $1`
+
+	// define the reset
+	var reset = models.PasswordReset{}
+
+	// scan the row into the reset
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&reset.TokenHash, &reset.UserId, &reset.ExpiresAt, &reset.UsedAt, &reset.CreatedAt)
+
+	// check if the reset doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting password reset at GetPasswordResetByHash: %v", err)
+	}
+
+	// return the reset
+	return &reset, nil
+}
+
+// GetLatestPasswordReset is a method that returns the last password reset sent to a user,
+// it returns nil if none was sent
+func (r *PostgresRepository) GetLatestPasswordReset(ctx context.Context, userId string) (*models.PasswordReset, error) {
+	// define the query
+	query := `SELECT token_hash, user_id, expires_at, used_at, created_at FROM password_resets WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+
+	// define the reset
+	var reset = models.PasswordReset{}
+
+	// scan the row into the reset
+	err := r.db.QueryRowContext(ctx, query, userId).Scan(&reset.TokenHash, &reset.UserId, &reset.ExpiresAt, &reset.UsedAt, &reset.CreatedAt)
+
+	// check if no reset was sent
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting password reset at GetLatestPasswordReset: %v", err)
+	}
+
+	// return the reset
+	return &reset, nil
+}
+
+// UsePasswordReset is a method that marks a password reset as used, along with every other unused reset of its user
+// so the older links stop working. It returns false if it was already used or expired.
+func (r *PostgresRepository) UsePasswordReset(ctx context.Context, hash string) (bool, error) {
+	// define the query, the condition on used_at makes concurrent uses of the same token fail
+	query := `UPDATE password_resets SET used_at = NOW()
+		WHERE used_at IS NULL AND user_id = (
+			SELECT user_id FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		)
+		RETURNING token_hash`
+
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, query, hash)
+	if err != nil {
+		return false, fmt.Errorf("error using password reset at UsePasswordReset: %v", err)
+	}
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at UsePasswordReset: %v", err)
+		}
+	}()
+
+	// check if the reset of the token was marked
+	used := false
+	for rows.Next() {
+		var tokenHash string
+		if err := rows.Scan(&tokenHash); err != nil {
+			return false, fmt.Errorf("error scanning password reset at UsePasswordReset: %v", err)
+		}
+		used = used || tokenHash == hash
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error iterating password resets at UsePasswordReset: %v", err)
+	}
+
+	// return true if the reset was marked
+	return used, nil
+}
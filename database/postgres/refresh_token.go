@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+)
+
+// InsertRefreshToken is a method that inserts a refresh token into the database
+func (r *PostgresRepository) InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	// define the query
+	query := `INSERT INTO refresh_tokens (id, user_id, family_id, scope, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, token.Id, token.UserId, token.FamilyId, token.Scope, token.TokenHash, token.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting refresh token at InsertRefreshToken: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetRefreshTokenByHash is a method that returns a refresh token by its hash, it returns nil if it doesn't exist
+func (r *PostgresRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	// define the query
+	query := `SELECT id, user_id, family_id, scope, token_hash, expires_at, used_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = $1`
+
+	// define the token
+	var token = models.RefreshToken{}
+
+	// scan the row into the token
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&token.Id, &token.UserId, &token.FamilyId, &token.Scope, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt)
+
+	// check if the token doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting refresh token at GetRefreshTokenByHash: %v", err)
+	}
+
+	// return the token
+	return &token, nil
+}
+
+// UseRefreshToken is a method that marks a refresh token as used.
+// It returns false if the token was already used, which means it is being replayed.
+func (r *PostgresRepository) UseRefreshToken(ctx context.Context, id string) (bool, error) {
+	// define the query, the condition makes concurrent uses of the same token fail
+	query := `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("error using refresh token at UseRefreshToken: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at UseRefreshToken: %v", err)
+	}
+
+	// return true if the token was marked
+	return rowsAffected == 1, nil
+}
+
+// RevokeRefreshTokenFamily is a method that revokes every refresh token of a family
+func (r *PostgresRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	// define the query
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, familyId)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error revoking refresh token family at RevokeRefreshTokenFamily: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// RevokeUserRefreshTokens is a method that revokes every refresh token of a user
+func (r *PostgresRepository) RevokeUserRefreshTokens(ctx context.Context, userId string) error {
+	// define the query
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, userId)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error revoking user refresh tokens at RevokeUserRefreshTokens: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"time"
+)
+
+// RevokeToken is a method that stores a revoked token, revoking it twice is not an error
+func (r *PostgresRepository) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	// define the query
+	query := `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, token.Jti, token.UserId, token.ExpiresAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error revoking token at RevokeToken: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// IsTokenRevoked is a method that checks if a token has been revoked
+func (r *PostgresRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	// define the query
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	// scan the result
+	var revoked bool
+	err := r.db.QueryRowContext(ctx, query, jti).Scan(&revoked)
+
+	// check if there was an error
+	if err != nil {
+		return false, fmt.Errorf("error checking token at IsTokenRevoked: %v", err)
+	}
+
+	// return the result
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedTokens is a method that deletes the revoked tokens that already expired
+func (r *PostgresRepository) DeleteExpiredRevokedTokens(ctx context.Context) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error deleting revoked tokens at DeleteExpiredRevokedTokens: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// RevokeUserTokens is a method that revokes every token of a user issued before the given time
+func (r *PostgresRepository) RevokeUserTokens(ctx context.Context, userId string, before time.Time) error {
+	// define the query
+	query := `INSERT INTO user_token_revocations (user_id, revoked_before) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, userId, before)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error revoking user tokens at RevokeUserTokens: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetUserTokensRevokedBefore is a method that returns the time before which the tokens of a user are revoked,
+// it returns the zero time if they have never been revoked
+func (r *PostgresRepository) GetUserTokensRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	// define the query
+	query := `SELECT revoked_before FROM user_token_revocations WHERE user_id = $1`
+
+	// scan the result
+	var before time.Time
+	err := r.db.QueryRowContext(ctx, query, userId).Scan(&before)
+
+	// check if the tokens have never been revoked
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting user revocation at GetUserTokensRevokedBefore: %v", err)
+	}
+
+	// return the time
+	return before, nil
+}
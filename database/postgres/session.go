@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/models"
+	"time"
+)
+
+// sessionColumns are the columns of the sessions that are scanned by scanSession
+const sessionColumns = "id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at"
+
+// scanSession is a function that scans a session from a row
+func scanSession(row scanner) (*models.Session, error) {
+	// define the session
+	var session = models.Session{}
+
+	// scan the row into the session
+	err := row.Scan(&session.Id, &session.UserId, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// return the session
+	return &session, nil
+}
+
+// InsertSession is a method that inserts a session into the database
+func (r *PostgresRepository) InsertSession(ctx context.Context, session *models.Session) error {
+	// define the query
+	query := `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at) VALUES ($1, $2, $3, $4, $5, $5)`
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, session.Id, session.UserId, session.UserAgent, session.IP, session.CreatedAt)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error inserting session at InsertSession: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// GetSession is a method that returns a session by id, it returns nil if it doesn't exist
+func (r *PostgresRepository) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	// scan the row into the session
+	session, err := scanSession(r.db.QueryRowContext(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id))
+
+	// check if the session doesn't exist
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	// check if there was an error
+	if err != nil {
+		return nil, fmt.Errorf("error getting session at GetSession: %v", err)
+	}
+
+	// return the session
+	return session, nil
+}
+
+// ListUserSessions is a method that returns the sessions of a user that are not revoked, the most recent first
+func (r *PostgresRepository) ListUserSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	// define the query
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC"
+
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting sessions at ListUserSessions: %v", err)
+	}
+
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at ListUserSessions: %v", err)
+		}
+	}()
+
+	// define the sessions
+	sessions := make([]*models.Session, 0)
+
+	// iterate over the rows
+	for rows.Next() {
+		// scan the row into the session
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning session row at ListUserSessions: %v", err)
+		}
+
+		// append the session to the list of sessions
+		sessions = append(sessions, session)
+	}
+
+	// check if there was an error iterating over the rows
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	// return the sessions
+	return sessions, nil
+}
+
+// RevokeSession is a method that revokes a session of a user, it returns false if the user has no such active session
+func (r *PostgresRepository) RevokeSession(ctx context.Context, userId, id string) (bool, error) {
+	// define the query
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	// execute the query
+	result, err := r.db.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return false, fmt.Errorf("error revoking session at RevokeSession: %v", err)
+	}
+
+	// get the rows affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected at RevokeSession: %v", err)
+	}
+
+	// return true if the session was revoked
+	return rowsAffected == 1, nil
+}
+
+// RevokeUserSessions is a method that revokes every active session of a user
+func (r *PostgresRepository) RevokeUserSessions(ctx context.Context, userId string) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userId)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error revoking sessions at RevokeUserSessions: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// TouchSession is a method that records the last time a session was seen
+func (r *PostgresRepository) TouchSession(ctx context.Context, id string, seenAt time.Time) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = $1 WHERE id = $2", seenAt, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error touching session at TouchSession: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// DeleteStaleSessions is a method that removes the sessions that were last seen before a time,
+// their refresh tokens have expired so they can't be used anymore
+func (r *PostgresRepository) DeleteStaleSessions(ctx context.Context, before time.Time) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE last_seen_at < $1", before)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error deleting stale sessions at DeleteStaleSessions: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
@@ -3,14 +3,40 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is the postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+// userColumns are the columns of the users that are scanned by extractUserFromResult
+const userColumns = "id, email, password, role, email_verified, display_name, locale, timezone, deletion_scheduled_at, disabled_at"
+
+// userDestinations is a function that returns the destinations of the userColumns of a row
+func userDestinations(user *models.User) []interface{} {
+	return []interface{}{
+		&user.Id, &user.Email, &user.Password, &user.Role, &user.EmailVerified,
+		&user.DisplayName, &user.Locale, &user.Timezone, &user.DeletionScheduledAt, &user.DisabledAt,
+	}
+}
+
 // InsertUser is a method that inserts a user into the database
 func (r *PostgresRepository) InsertUser(ctx context.Context, user *models.User) error {
 	// execute the query
-	_, err := r.db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES ($1, $2, $3)", user.Id, user.Email, user.Password)
+	_, err := r.db.ExecContext(ctx, "INSERT INTO users (id, email, password, role) VALUES ($1, $2, $3, $4)", user.Id, user.Email, user.Password, user.Role)
+
+	// check if the email is already in use
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == "users_email_key" {
+		return fmt.Errorf("error inserting user: %w", repository.ErrDuplicateEmail)
+	}
 
 	// check if there was an error
 	if err != nil {
@@ -24,7 +50,7 @@ func (r *PostgresRepository) InsertUser(ctx context.Context, user *models.User)
 // GetUserById is a method that returns a user from the database
 func (r *PostgresRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
 	// execute the query
-	rows, err := r.db.QueryContext(ctx, "SELECT id, email, password FROM users WHERE id = $1", id)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
 
 	// check if there was an error
 	if err != nil {
@@ -47,7 +73,7 @@ func (r *PostgresRepository) GetUserById(ctx context.Context, id string) (*model
 // GetUserByEmail is a method that returns a user from the database
 func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	// execute the query
-	rows, err := r.db.QueryContext(ctx, "SELECT id, email, password FROM users WHERE email = $1", email)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE lower(email) = lower($1)", email)
 
 	// check if there was an error
 	if err != nil {
@@ -74,7 +100,7 @@ func extractUserFromResult(rows *sql.Rows) (*models.User, error) {
 	// iterate over the rows
 	for rows.Next() {
 		// scan the row into the user
-		err := rows.Scan(&user.Id, &user.Email, &user.Password)
+		err := rows.Scan(userDestinations(&user)...)
 
 		// check if there was an error scanning the row
 		if err != nil {
@@ -90,3 +116,149 @@ func extractUserFromResult(rows *sql.Rows) (*models.User, error) {
 	// return the user
 	return &user, nil
 }
+
+// UpdateUserPassword is a method that updates the password hash of a user
+func (r *PostgresRepository) UpdateUserPassword(ctx context.Context, id, password string) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET password = $1, updated_at = NOW() WHERE id = $2", password, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error updating password at UpdateUserPassword: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// MarkUserEmailVerified is a method that marks the email of a user as verified
+func (r *PostgresRepository) MarkUserEmailVerified(ctx context.Context, id string) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET email_verified = TRUE, updated_at = NOW() WHERE id = $1", id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error verifying email at MarkUserEmailVerified: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// UpdateUserProfile is a method that updates the profile fields of a user
+func (r *PostgresRepository) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	// define the query
+	query := "UPDATE users SET display_name = $1, locale = $2, timezone = $3, updated_at = NOW() WHERE id = $4"
+
+	// execute the query
+	_, err := r.db.ExecContext(ctx, query, user.DisplayName, user.Locale, user.Timezone, user.Id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error updating profile at UpdateUserProfile: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ScheduleUserDeletion is a method that schedules the purge of a user, a nil time cancels it
+func (r *PostgresRepository) ScheduleUserDeletion(ctx context.Context, id string, at *time.Time) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET deletion_scheduled_at = $1, updated_at = NOW() WHERE id = $2", at, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error scheduling deletion at ScheduleUserDeletion: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// ListUsers is a method that returns a page of the users whose email contains the search, and the total of them
+func (r *PostgresRepository) ListUsers(ctx context.Context, search string, page, rowsPerPage int64) ([]*models.User, int64, error) {
+	// escape the wildcards of the search, it matches anywhere in the email
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(search)) + "%"
+
+	// define the query
+	query := "SELECT " + userColumns + " FROM users WHERE email LIKE $1 ORDER BY created_at, id LIMIT $2 OFFSET $3"
+
+	// execute the query
+	rows, err := r.db.QueryContext(ctx, query, pattern, rowsPerPage, (page-1)*rowsPerPage)
+
+	// check if there was an error
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting users at ListUsers: %v", err)
+	}
+
+	// define a defer to close the rows
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			fmt.Printf("error closing rows at ListUsers: %v", err)
+		}
+	}()
+
+	// define the users
+	users := make([]*models.User, 0)
+
+	// iterate over the rows
+	for rows.Next() {
+		// define the user
+		var user = models.User{}
+
+		// scan the row into the user
+		if err := rows.Scan(userDestinations(&user)...); err != nil {
+			return nil, 0, fmt.Errorf("error scanning user row at ListUsers: %v", err)
+		}
+
+		// append the user to the list of users
+		users = append(users, &user)
+	}
+
+	// check if there was an error iterating over the rows
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over rows: %v", err)
+	}
+
+	// define the total number of users
+	var total int64
+
+	// count the users that match the search
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE email LIKE $1", pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error scanning total row at ListUsers: %v", err)
+	}
+
+	// return the users and the total
+	return users, total, nil
+}
+
+// SetUserDisabled is a method that disables a user at a time, a nil time enables it again
+func (r *PostgresRepository) SetUserDisabled(ctx context.Context, id string, at *time.Time) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET disabled_at = $1, updated_at = NOW() WHERE id = $2", at, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error disabling user at SetUserDisabled: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// UpdateUserRole is a method that changes the role of a user
+func (r *PostgresRepository) UpdateUserRole(ctx context.Context, id, role string) error {
+	// execute the query
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2", role, id)
+
+	// check if there was an error
+	if err != nil {
+		return fmt.Errorf("error updating role at UpdateUserRole: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
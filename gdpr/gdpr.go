@@ -0,0 +1,95 @@
+package gdpr
+
+import (
+	"context"
+	"errors"
+	"log"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"platzi/go/rest-ws/validation"
+	"strings"
+)
+
+// ErrUserNotFound is returned when the user of a request doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// attemptKeys is a function that returns the keys of the failed logins of a user, they are not linked by id
+func attemptKeys(user *models.User) []string {
+	return []string{loginguard.AccountKey(user.Email), loginguard.MFAKey(user.Id)}
+}
+
+// FindUser is a function that returns a user by id, or by email when the reference has an @
+func FindUser(ctx context.Context, repo repository.Repository, reference string) (*models.User, error) {
+	// get the user from the database
+	var user *models.User
+	var err error
+	if strings.Contains(reference, "@") {
+		email, normalizeErr := validation.NormalizeEmail(reference)
+		if normalizeErr != nil {
+			return nil, ErrUserNotFound
+		}
+		user, err = repo.GetUserByEmail(ctx, email)
+	} else {
+		user, err = repo.GetUserById(ctx, reference)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// check if the user exists
+	if user == nil || user.Id == "" {
+		return nil, ErrUserNotFound
+	}
+
+	// return the user
+	return user, nil
+}
+
+// Export is a function that returns everything that is stored about a user, referenced by id or email
+func Export(ctx context.Context, repo repository.Repository, reference string) (*models.UserExport, error) {
+	// get the user
+	user, err := FindUser(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	// export the data of the user
+	export, err := repo.ExportUserData(ctx, user.Id, attemptKeys(user))
+	if err != nil {
+		return nil, err
+	}
+
+	// check if the user was deleted meanwhile
+	if export == nil {
+		return nil, ErrUserNotFound
+	}
+
+	// return the export
+	return export, nil
+}
+
+// Erase is a function that deletes a user, referenced by id or email, and everything linked to it in one transaction.
+// Its sessions are deleted too, so its access tokens are rejected once the cache of the sessions expires.
+func Erase(ctx context.Context, repo repository.Repository, reference string) error {
+	// get the user
+	user, err := FindUser(ctx, repo, reference)
+	if err != nil {
+		return err
+	}
+
+	// erase the user
+	erased, err := repo.EraseUser(ctx, user.Id, attemptKeys(user))
+	if err != nil {
+		return err
+	}
+	if !erased {
+		return ErrUserNotFound
+	}
+
+	// log the erasure, without personal data
+	log.Printf("Erased user %s", user.Id)
+
+	// return nil as error
+	return nil
+}
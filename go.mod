@@ -12,3 +12,5 @@ require (
 )
 
 require github.com/rs/cors v1.10.0
+
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/mail"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/validation"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePasswordRequest is a struct that represents the request of the ChangePasswordHandler.
+// The current password is not sent by the accounts without password, they set their first one.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// UpdateProfileRequest is a struct that represents the request of the UpdateProfileHandler,
+// the fields that are not sent are kept
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+// DeleteAccountRequest is a struct that represents the request of the DeleteAccountHandler.
+// The password is not sent by the accounts without password.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccountResponse is a struct that represents the response of the DeleteAccountHandler
+type DeleteAccountResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// errReauthenticationRequired is returned when an account without password changes it without a recent login
+var errReauthenticationRequired = errors.New("log in again to confirm the change")
+
+// restoreAccount is a function that cancels the deletion of the account of a user, if it was scheduled
+func restoreAccount(r *http.Request, s server.Server, user *models.User) error {
+	// check if the deletion was scheduled
+	if user.DeletionScheduledAt == nil {
+		return nil
+	}
+
+	// cancel the deletion
+	if err := s.Repository().ScheduleUserDeletion(r.Context(), user.Id, nil); err != nil {
+		return err
+	}
+	user.DeletionScheduledAt = nil
+	log.Printf("Deletion of the account of user %s cancelled by login", user.Id)
+
+	// record the change, the user logging in is the actor
+	audit.Record(r, s.Repository(), audit.Event{Action: "user.restore", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id})
+
+	// return nil as error
+	return nil
+}
+
+// currentUser is a function that returns the user authenticated with a token, it responds the error otherwise.
+// Api keys can't manage the account, so they are rejected.
+func currentUser(w http.ResponseWriter, r *http.Request, s server.Server) (*models.AppClaims, *models.User, bool) {
+	// get the claims of the authenticated user
+	claims, ok := middlewares.ClaimsFromContext(r.Context())
+	if !ok {
+		respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+		return nil, nil, false
+	}
+
+	// api keys can't manage the account
+	if claims.ApiKeyId != "" {
+		respondError(w, http.StatusForbidden, errors.New("api keys can't manage the account"))
+		return nil, nil, false
+	}
+
+	// get the user from the database
+	user, err := s.Repository().GetUserById(r.Context(), claims.UserId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return nil, nil, false
+	}
+
+	// check if the user exists
+	if user == nil || user.Id == "" {
+		respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+		return nil, nil, false
+	}
+
+	// return the claims and the user
+	return claims, user, true
+}
+
+// checkPassword is a function that checks the password of the authenticated user before a sensitive change,
+// the failures count as failed logins so a stolen token can't be used to guess it. The accounts without password
+// log in again with their identity provider instead. It responds the error otherwise.
+func checkPassword(w http.ResponseWriter, r *http.Request, s server.Server, claims *models.AppClaims, user *models.User, password string) bool {
+	// the accounts created with the identity provider have no password to check
+	if user.Password == "" {
+		return checkRecentLogin(w, r, s, claims)
+	}
+
+	// check if the account must wait before trying again
+	accountKey := loginguard.AccountKey(user.Email)
+	if wait, err := s.LoginGuard().Check(r.Context(), accountKey); err != nil {
+		if errors.Is(err, loginguard.ErrLocked) || errors.Is(err, loginguard.ErrThrottled) {
+			respondTooManyRequests(w, wait, err)
+			return false
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return false
+	}
+
+	// compare the password
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if err := s.LoginGuard().Fail(r.Context(), accountKey); err != nil {
+			log.Println(err)
+		}
+		respondError(w, http.StatusForbidden, errors.New("invalid password"))
+		return false
+	}
+
+	// return true as the password is right
+	return true
+}
+
+// checkRecentLogin is a function that checks that the session of the token was started within the
+// reauthentication max age, so a stolen token can't confirm a sensitive change. It responds the error otherwise.
+func checkRecentLogin(w http.ResponseWriter, r *http.Request, s server.Server, claims *models.AppClaims) bool {
+	// get the session of the token, the tokens issued without a session can't tell when the user logged in
+	var session *models.Session
+	if claims.SessionId != "" {
+		var err error
+		session, err = s.Repository().GetSession(r.Context(), claims.SessionId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return false
+		}
+	}
+
+	// check if the user logged in recently
+	if session == nil || session.UserId != claims.UserId || time.Since(session.CreatedAt) > s.Config().ReauthenticationMaxAge {
+		respondError(w, http.StatusForbidden, errReauthenticationRequired)
+		return false
+	}
+
+	// return true as the login is recent
+	return true
+}
+
+// ChangePasswordHandler is a function that changes the password of the authenticated user.
+// Every other session is logged out, the caller gets a new pair of tokens to keep its own.
+func ChangePasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user
+		claims, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req ChangePasswordRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the current password
+		if !checkPassword(w, r, s, claims, user, req.CurrentPassword) {
+			return
+		}
+
+		// check the new password against the policy
+		if err := s.PasswordPolicy().Validate(req.NewPassword, user.Email); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// hash the password
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// update the password
+		if err := s.Repository().UpdateUserPassword(r.Context(), user.Id, string(hashedPassword)); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.password_change", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// log out every session, including this one
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// start a new session for this device with the scope it had
+		resp, err := startSession(r, s, user, models.ParseScope(claims.Scope))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// UpdateProfileHandler is a function that updates the profile fields sent by the authenticated user
+func UpdateProfileHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user
+		_, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req UpdateProfileRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// keep the user as it was for the audit log
+		before := newUserResponse(user)
+
+		// update the display name
+		if req.DisplayName != nil {
+			name, err := validation.NormalizeDisplayName(*req.DisplayName)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			user.DisplayName = name
+		}
+
+		// update the locale
+		if req.Locale != nil {
+			if err := validation.ValidateLocale(*req.Locale); err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			user.Locale = *req.Locale
+		}
+
+		// update the timezone
+		if req.Timezone != nil {
+			if err := validation.ValidateTimezone(*req.Timezone); err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			user.Timezone = *req.Timezone
+		}
+
+		// save the profile
+		if err := s.Repository().UpdateUserProfile(r.Context(), user); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.update", ResourceType: audit.ResourceUser, ResourceId: user.Id, Before: before, After: newUserResponse(user)})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(newUserResponse(user))
+	}
+}
+
+// DeleteAccountHandler is a function that schedules the deletion of the account of the authenticated user.
+// Every session is logged out and the account is purged after the grace period, unless the user logs in again.
+func DeleteAccountHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user
+		claims, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req DeleteAccountRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the password
+		if !checkPassword(w, r, s, claims, user, req.Password) {
+			return
+		}
+
+		// schedule the deletion
+		at := time.Now().Add(s.Config().AccountDeletionGracePeriod)
+		if err := s.Repository().ScheduleUserDeletion(r.Context(), user.Id, &at); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change
+		before := newUserResponse(user)
+		user.DeletionScheduledAt = &at
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.delete", ResourceType: audit.ResourceUser, ResourceId: user.Id, Before: before, After: newUserResponse(user)})
+
+		// log out every session
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+		}
+
+		// tell the user how to restore the account
+		err := s.Mailer().Send(r.Context(), mail.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Your account will be deleted on %s.\n\n"+
+				"If you change your mind, log in before that date to keep it.\n", at.Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Println(err)
+		}
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// set the status code
+		w.WriteHeader(http.StatusAccepted)
+
+		// encode the response
+		json.NewEncoder(w).Encode(DeleteAccountResponse{DeletionScheduledAt: at})
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"testing"
+	"time"
+)
+
+// sessionRepository is an in-memory repository of the sessions
+type sessionRepository struct {
+	*identityRepository
+	sessions map[string]*models.Session
+}
+
+func (r *sessionRepository) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	return r.sessions[id], nil
+}
+
+func TestCheckPasswordOfAccountWithoutPassword(t *testing.T) {
+	user := &models.User{Id: "user-1", Email: "jane@example.com"}
+	repo := &sessionRepository{
+		identityRepository: newIdentityRepository(user),
+		sessions: map[string]*models.Session{
+			"recent":  {Id: "recent", UserId: user.Id, CreatedAt: time.Now().Add(-time.Minute)},
+			"old":     {Id: "old", UserId: user.Id, CreatedAt: time.Now().Add(-time.Hour)},
+			"another": {Id: "another", UserId: "user-2", CreatedAt: time.Now()},
+		},
+	}
+	s := &repositoryServer{repo: repo, config: &server.Config{ReauthenticationMaxAge: 5 * time.Minute}}
+
+	tests := []struct {
+		name      string
+		sessionId string
+		want      bool
+	}{
+		{name: "recent login", sessionId: "recent", want: true},
+		{name: "old login", sessionId: "old"},
+		{name: "session of another user", sessionId: "another"},
+		{name: "unknown session", sessionId: "unknown"},
+		{name: "token without session"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/me", nil)
+			claims := &models.AppClaims{UserId: user.Id, SessionId: tt.sessionId}
+
+			// the password sent is ignored, the account has none
+			got := checkPassword(w, r, s, claims, user, "anything")
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if !got && w.Code != http.StatusForbidden {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Pagination of the admin lists
+const (
+	defaultRowsPerPage = 20
+	maxRowsPerPage     = 100
+)
+
+// errAccountDisabled is returned when a disabled user tries to log in or get new tokens
+var errAccountDisabled = errors.New("account disabled")
+
+// ListUsersResponse is a struct that represents the response of the ListUsersHandler
+type ListUsersResponse struct {
+	Users []SignUpResponse `json:"users"`
+	Total int64            `json:"total"`
+}
+
+// UpdateUserRoleRequest is a struct that represents the request of the UpdateUserRoleHandler
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// pagination is a function that reads the page and rowsPerPage query params, they are optional
+func pagination(r *http.Request) (int64, int64, error) {
+	// define the defaults
+	page, rowsPerPage := int64(1), int64(defaultRowsPerPage)
+
+	// read the page
+	if value := r.URL.Query().Get("page"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			return 0, 0, errors.New("The page must be a positive number")
+		}
+		page = parsed
+	}
+
+	// read the rows per page
+	if value := r.URL.Query().Get("rowsPerPage"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxRowsPerPage {
+			return 0, 0, errors.New("The rowsPerPage must be a number between 1 and 100")
+		}
+		rowsPerPage = parsed
+	}
+
+	// return the pagination
+	return page, rowsPerPage, nil
+}
+
+// targetUser is a function that returns the user of the id of the route, it responds the error otherwise
+func targetUser(w http.ResponseWriter, r *http.Request, s server.Server) (*models.User, bool) {
+	// get the user from the database
+	user, err := s.Repository().GetUserById(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return nil, false
+	}
+
+	// check if the user exists
+	if user == nil || user.Id == "" {
+		respondError(w, http.StatusNotFound, errors.New("user not found"))
+		return nil, false
+	}
+
+	// return the user
+	return user, true
+}
+
+// notSelf is a function that rejects the changes of an admin to its own account, so it can't lock itself out
+func notSelf(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	// get the claims of the admin
+	claims, ok := middlewares.ClaimsFromContext(r.Context())
+	if !ok {
+		respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+		return false
+	}
+
+	// check if the admin is the user
+	if claims.UserId == user.Id {
+		respondError(w, http.StatusBadRequest, errors.New("admins can't change their own account here"))
+		return false
+	}
+
+	// return true as the user is another one
+	return true
+}
+
+// respondUser is a function that responds a user
+func respondUser(w http.ResponseWriter, user *models.User) {
+	// set the header
+	w.Header().Set("Content-Type", "application/json")
+
+	// encode the response
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// ListUsersHandler is a function that lists the users, optionally searching by email
+func ListUsersHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the pagination
+		page, rowsPerPage, err := pagination(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// list the users from the database
+		users, total, err := s.Repository().ListUsers(r.Context(), r.URL.Query().Get("email"), page, rowsPerPage)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error listing users"))
+			return
+		}
+
+		// create a new response, without the passwords
+		res := ListUsersResponse{Users: make([]SignUpResponse, 0, len(users)), Total: total}
+		for _, user := range users {
+			res.Users = append(res.Users, newUserResponse(user))
+		}
+
+		// set the content type
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// GetUserHandler is a function that returns a user
+func GetUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// respond the user
+		respondUser(w, user)
+	}
+}
+
+// DisableUserHandler is a function that disables a user and logs out every session of it
+func DisableUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok || !notSelf(w, r, user) {
+			return
+		}
+
+		// disable the user, keeping the first time it was disabled
+		before := newUserResponse(user)
+		if !user.Disabled() {
+			now := time.Now()
+			if err := s.Repository().SetUserDisabled(r.Context(), user.Id, &now); err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+				return
+			}
+			user.DisabledAt = &now
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.disable", ResourceType: audit.ResourceUser, ResourceId: user.Id, Before: before, After: newUserResponse(user)})
+
+		// log out every session, the middleware rejects the revoked tokens
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// respond the user
+		respondUser(w, user)
+	}
+}
+
+// EnableUserHandler is a function that enables a disabled user
+func EnableUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// enable the user
+		before := newUserResponse(user)
+		if err := s.Repository().SetUserDisabled(r.Context(), user.Id, nil); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		user.DisabledAt = nil
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.enable", ResourceType: audit.ResourceUser, ResourceId: user.Id, Before: before, After: newUserResponse(user)})
+
+		// respond the user
+		respondUser(w, user)
+	}
+}
+
+// UpdateUserRoleHandler is a function that changes the role of a user.
+// The sessions of the user are logged out so its tokens don't keep the old role.
+func UpdateUserRoleHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok || !notSelf(w, r, user) {
+			return
+		}
+
+		// decode the request
+		var req UpdateUserRoleRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the role
+		if !models.IsValidRole(req.Role) {
+			respondError(w, http.StatusBadRequest, errors.New("invalid role"))
+			return
+		}
+
+		// nothing to do if the role doesn't change
+		if req.Role == user.Role {
+			respondUser(w, user)
+			return
+		}
+
+		// update the role
+		before := newUserResponse(user)
+		if err := s.Repository().UpdateUserRole(r.Context(), user.Id, req.Role); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		user.Role = req.Role
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.role_change", ResourceType: audit.ResourceUser, ResourceId: user.Id, Before: before, After: newUserResponse(user)})
+
+		// log out every session
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// respond the user
+		respondUser(w, user)
+	}
+}
+
+// ForcePasswordResetHandler is a function that makes a user choose a new password.
+// The current password stops working, every session is logged out and a reset link is emailed to the user.
+func ForcePasswordResetHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// remove the password, no password matches an empty hash
+		if err := s.Repository().UpdateUserPassword(r.Context(), user.Id, ""); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.password_reset_force", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// log out every session
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// email the reset link
+		if err := sendPasswordReset(r, s, user); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// respond that the reset was sent
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/ksuid"
+)
+
+// apiKeyPrefix is the beginning of every api key, it makes them easy to recognize in logs and configs
+const apiKeyPrefix = "rws_"
+
+// CreateApiKeyRequest is a struct that represents the request of the CreateApiKeyHandler.
+// The scopes and the expiration are optional.
+type CreateApiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateApiKeyResponse is a struct that represents the response of the CreateApiKeyHandler.
+// The key is only returned once, it can't be recovered afterwards.
+type CreateApiKeyResponse struct {
+	Key    string         `json:"key"`
+	ApiKey *models.ApiKey `json:"api_key"`
+}
+
+// ListApiKeysResponse is a struct that represents the response of the ListApiKeysHandler
+type ListApiKeysResponse struct {
+	ApiKeys []*models.ApiKey `json:"api_keys"`
+}
+
+// CreateApiKeyHandler is a function that creates a named api key for the authenticated user
+func CreateApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// api keys can't create api keys, a new key would outlive the revocation of the one that created it
+		if claims.ApiKeyId != "" {
+			respondError(w, http.StatusForbidden, errors.New("api keys can't manage the api keys"))
+			return
+		}
+
+		// decode the request
+		var req CreateApiKeyRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// validate the request
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			respondError(w, http.StatusBadRequest, errors.New("the name is required"))
+			return
+		}
+		if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+			respondError(w, http.StatusBadRequest, errors.New("the expiration must be in the future"))
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !models.IsValidScope(scope) {
+				respondError(w, http.StatusBadRequest, fmt.Errorf("unknown scope %q", scope))
+				return
+			}
+		}
+
+		// the key can't do more than the token that creates it, a key without scopes would get every scope of the role
+		if len(req.Scopes) == 0 {
+			req.Scopes = models.ParseScope(claims.Scope)
+		}
+		if len(req.Scopes) == 0 || !claims.HasScopes(req.Scopes...) {
+			respondError(w, http.StatusForbidden, errors.New("the scopes of the api key must be granted to the token"))
+			return
+		}
+
+		// generate the key
+		secret, _, err := newOpaqueToken()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		key := apiKeyPrefix + secret
+
+		// generate the id
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// create the api key
+		apiKey := &models.ApiKey{
+			Id:        id.String(),
+			UserId:    claims.UserId,
+			Name:      req.Name,
+			Prefix:    key[:len(apiKeyPrefix)+6],
+			KeyHash:   middlewares.HashApiKey(key),
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+			CreatedAt: time.Now(),
+		}
+
+		// insert the api key
+		if err := s.Repository().InsertApiKey(r.Context(), apiKey); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error creating api key"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "api_key.create", ResourceType: audit.ResourceApiKey, ResourceId: apiKey.Id, After: apiKey})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// set the status code
+		w.WriteHeader(http.StatusCreated)
+
+		// encode the response
+		json.NewEncoder(w).Encode(CreateApiKeyResponse{Key: key, ApiKey: apiKey})
+	}
+}
+
+// ListApiKeysHandler is a function that lists the api keys of the authenticated user
+func ListApiKeysHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// list the api keys
+		apiKeys, err := s.Repository().ListApiKeysByUser(r.Context(), claims.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error listing api keys"))
+			return
+		}
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(ListApiKeysResponse{ApiKeys: apiKeys})
+	}
+}
+
+// RevokeApiKeyHandler is a function that revokes an api key of the authenticated user
+func RevokeApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// api keys can't manage the api keys
+		if claims.ApiKeyId != "" {
+			respondError(w, http.StatusForbidden, errors.New("api keys can't manage the api keys"))
+			return
+		}
+
+		// revoke the api key, only the keys of the user can be revoked
+		revoked, err := s.Repository().RevokeApiKey(r.Context(), claims.UserId, mux.Vars(r)["id"])
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error revoking api key"))
+			return
+		}
+
+		// check if the key was found
+		if !revoked {
+			respondError(w, http.StatusNotFound, errors.New("api key not found"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "api_key.revoke", ResourceType: audit.ResourceApiKey, ResourceId: mux.Vars(r)["id"]})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
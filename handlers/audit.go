@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"time"
+)
+
+// ListAuditEventsResponse is a struct that represents the response of the ListAuditEventsHandler
+type ListAuditEventsResponse struct {
+	Events []*models.AuditEvent `json:"events"`
+	Total  int64                `json:"total"`
+}
+
+// auditTime is a function that reads a time of the query of the audit log, nil when it is not set
+func auditTime(r *http.Request, name string) (*time.Time, error) {
+	// check if the time is set
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	// parse the time
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("The %s must be a RFC 3339 time", name)
+	}
+
+	// return the time
+	return &parsed, nil
+}
+
+// ListAuditEventsHandler is a function that lists the audit events, newest first, filtered by actor, action,
+// resource and time range
+func ListAuditEventsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the pagination
+		page, rowsPerPage, err := pagination(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// define the filter
+		query := r.URL.Query()
+		filter := models.AuditFilter{
+			ActorId:      query.Get("actor"),
+			Action:       query.Get("action"),
+			ResourceType: query.Get("resource_type"),
+			ResourceId:   query.Get("resource_id"),
+		}
+
+		// read the time range
+		if filter.From, err = auditTime(r, "from"); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if filter.To, err = auditTime(r, "to"); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the time range
+		if filter.From != nil && filter.To != nil && filter.To.Before(*filter.From) {
+			respondError(w, http.StatusBadRequest, errors.New("The from time must be before the to time"))
+			return
+		}
+
+		// list the events from the database
+		events, total, err := s.Repository().ListAuditEvents(r.Context(), filter, page, rowsPerPage)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error listing audit events"))
+			return
+		}
+
+		// set the content type
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(ListAuditEventsResponse{Events: events, Total: total})
+	}
+}
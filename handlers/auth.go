@@ -5,13 +5,15 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/middlewares"
 	"platzi/go/rest-ws/models"
 	"platzi/go/rest-ws/repository"
 	"platzi/go/rest-ws/server"
-	"strings"
+	"platzi/go/rest-ws/validation"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/segmentio/ksuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -26,11 +28,51 @@ type SignUpLoginRequest struct {
 type SignUpResponse struct {
 	Id    string `json:"id"`
 	Email string `json:"email"`
+	Role  string `json:"role"`
+
+	// EmailVerified is false until the user follows the link of the verification email
+	EmailVerified bool `json:"email_verified"`
+
+	// Profile of the user
+	DisplayName string `json:"display_name"`
+	Locale      string `json:"locale"`
+	Timezone    string `json:"timezone"`
+
+	// DeletionScheduledAt is set while the account is waiting to be purged
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+
+	// DisabledAt is set while an admin has disabled the account
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+}
+
+// newUserResponse is a function that creates the response of a user, without its password
+func newUserResponse(user *models.User) SignUpResponse {
+	return SignUpResponse{
+		Id:                  user.Id,
+		Email:               user.Email,
+		Role:                user.Role,
+		EmailVerified:       user.EmailVerified,
+		DisplayName:         user.DisplayName,
+		Locale:              user.Locale,
+		Timezone:            user.Timezone,
+		DeletionScheduledAt: user.DeletionScheduledAt,
+		DisabledAt:          user.DisabledAt,
+	}
+}
+
+// LoginRequest is a struct that represents the request of the LoginHandler.
+// The scope is an optional space separated list, the token is granted every scope of the role without it.
+type LoginRequest struct {
+	SignUpLoginRequest
+	Scope string `json:"scope"`
 }
 
 // LoginResponse is a struct that represents the response of the LoginHandler
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
 }
 
 // SignUpHandler is a function that handles the sign up of a user
@@ -46,6 +88,19 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 			return
 		}
 
+		// validate and normalize the email
+		email, err := validation.NormalizeEmail(req.Email)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the password against the policy
+		if err := s.PasswordPolicy().Validate(req.Password, email); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
 		// generate the id
 		id, err := ksuid.NewRandom()
 		if err != nil {
@@ -64,23 +119,34 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 		// create the user
 		user := &models.User{
 			Id:       id.String(),
-			Email:    req.Email,
+			Email:    email,
 			Password: string(hashedPassword),
+			Role:     models.RoleViewer,
 		}
 
 		// insert the user
-		err = repository.InsertUser(r.Context(), user)
+		err = s.Repository().InsertUser(r.Context(), user)
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			respondError(w, http.StatusConflict, repository.ErrDuplicateEmail)
+			return
+		}
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, err)
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
 			return
 		}
 
-		// create the response
-		resp := SignUpResponse{
-			Id:    user.Id,
-			Email: user.Email,
+		// record the sign up, the new user is the actor
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.signup", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id, After: newUserResponse(user)})
+
+		// send the verification email, the user can ask for another one if it fails
+		if err := sendEmailVerification(r, s, user); err != nil {
+			log.Println(err)
 		}
 
+		// create the response
+		resp := newUserResponse(user)
+
 		// set the header
 		w.Header().Set("Content-Type", "application/json")
 
@@ -97,7 +163,7 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 func LoginHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// define a variable to decode the request into
-		var request = SignUpLoginRequest{}
+		var request = LoginRequest{}
 
 		// decode the request
 		err := decode(r.Body, &request)
@@ -106,86 +172,139 @@ func LoginHandler(s server.Server) http.HandlerFunc {
 			return
 		}
 
-		// get the user from the database
-		user, err := repository.GetUserByEmail(r.Context(), request.Email)
+		// normalize the email, an invalid one can't match any user but still counts as a failure
+		email, err := validation.NormalizeEmail(request.Email)
 		if err != nil {
+			email = request.Email
+		}
+
+		// check if the account or the client ip must wait before trying again
+		accountKey, ipKey := loginguard.AccountKey(email), loginguard.IPKey(middlewares.ClientIP(r))
+		if wait, err := s.LoginGuard().Check(r.Context(), accountKey, ipKey); err != nil {
+			if errors.Is(err, loginguard.ErrLocked) || errors.Is(err, loginguard.ErrThrottled) {
+				respondTooManyRequests(w, wait, err)
+				return
+			}
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
 			return
 		}
 
-		// check if the user is nil
-		if user == nil {
-			respondError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
+		// get the user from the database
+		user, err := s.Repository().GetUserByEmail(r.Context(), email)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
 			return
 		}
 
-		// compare the password
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password))
-		if err != nil {
+		// compare the password, unknown emails count as failures too
+		if user == nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)) != nil {
+			if err := s.LoginGuard().Fail(r.Context(), accountKey, ipKey); err != nil {
+				log.Println(err)
+			}
 			respondError(w, http.StatusUnauthorized, errors.New("invalid credentials"))
 			return
 		}
 
-		// create the claims
-		claims := models.AppClaims{
-			UserId: user.Id,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour * 48).Unix(),
-			},
+		// forget the failures of the account, the ones of the ip are kept
+		if err := s.LoginGuard().Succeed(r.Context(), accountKey); err != nil {
+			log.Println(err)
 		}
 
-		// generate the token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		// check the account and issue the tokens
+		completeLogin(w, r, s, user, models.ParseScope(request.Scope))
+	}
+}
+
+// completeLogin is a function that finishes the login of an authenticated user: it checks that the account
+// can log in, asks for the second factor if the user has one, and starts a session with the requested scopes
+func completeLogin(w http.ResponseWriter, r *http.Request, s server.Server, user *models.User, requested []string) {
+	// check if an admin disabled the account
+	if user.Disabled() {
+		respondError(w, http.StatusForbidden, errAccountDisabled)
+		return
+	}
+
+	// check if the email has to be verified before logging in
+	if s.Config().RequireEmailVerification && !user.EmailVerified {
+		respondError(w, http.StatusForbidden, errors.New("email not verified"))
+		return
+	}
+
+	// check that at least one of the requested scopes can be granted
+	if len(requested) > 0 && len(models.GrantScopes(user.Role, requested)) == 0 {
+		respondError(w, http.StatusBadRequest, errors.New("invalid_scope: none of the requested scopes can be granted"))
+		return
+	}
+
+	// get the second factor of the user
+	mfa, err := s.Repository().GetUserMFA(r.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return
+	}
 
-		// sign the token
-		signedToken, err := token.SignedString([]byte(s.Config().JwtSecret))
+	// users with a second factor exchange a pending token at /login/mfa instead
+	if mfa.Enabled() {
+		// sign the pending token
+		mfaToken, err := signMFAToken(s, user, models.FormatScope(requested))
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
 			return
 		}
 
-		// create the response
-		resp := LoginResponse{
-			Token: signedToken,
-		}
-
 		// set the header
 		w.Header().Set("Content-Type", "application/json")
 
 		// encode the response
-		json.NewEncoder(w).Encode(resp)
+		json.NewEncoder(w).Encode(MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(s.Config().MFAPendingTTL.Seconds()),
+		})
+		return
+	}
 
+	// logging in during the grace period restores a deleted account
+	if err := restoreAccount(r, s, user); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return
 	}
+
+	// start a new session and issue its tokens
+	resp, err := startSession(r, s, user, requested)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return
+	}
+
+	// record the login
+	audit.Record(r, s.Repository(), audit.Event{Action: "user.login", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id})
+
+	// set the header
+	w.Header().Set("Content-Type", "application/json")
+
+	// encode the response
+	json.NewEncoder(w).Encode(resp)
 }
 
 // MeHandler is a function that handles the me endpoint
 func MeHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// get the token from the header
-		tokenString := strings.TrimSpace(r.Header.Get("Authorization"))
-
-		// parse the token
-		token, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(s.Config().JwtSecret), nil
-		})
-
-		// check if there is an error
-		if err != nil {
-			respondError(w, http.StatusUnauthorized, errors.New("invalid token"))
-			return
-		}
-
-		// get the claims
-		claims, ok := token.Claims.(*models.AppClaims)
-		if !ok || !token.Valid {
-			respondError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
 			return
 		}
 
 		// get the user from the database
-		user, err := repository.GetUserById(r.Context(), claims.UserId)
+		user, err := s.Repository().GetUserById(r.Context(), claims.UserId)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
@@ -199,10 +318,7 @@ func MeHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// create the response
-		resp := SignUpResponse{
-			Id:    user.Id,
-			Email: user.Email,
-		}
+		resp := newUserResponse(user)
 
 		// set the header
 		w.Header().Set("Content-Type", "application/json")
@@ -211,3 +327,106 @@ func MeHandler(s server.Server) http.HandlerFunc {
 		json.NewEncoder(w).Encode(resp)
 	}
 }
+
+// LogoutRequest is a struct that represents the request of the LogoutHandler.
+// The refresh token is optional, when it is sent its family is revoked too.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutHandler is a function that revokes the token used in the request
+func LogoutHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// decode the request, the body is optional
+		var req LogoutRequest
+		if r.ContentLength != 0 {
+			if err := decode(r.Body, &req); err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		// api keys are revoked through their own endpoint
+		if claims.ApiKeyId != "" {
+			respondError(w, http.StatusBadRequest, errors.New("api keys are revoked with DELETE /me/api-keys/{id}"))
+			return
+		}
+
+		// tokens issued before the jti was added can't be revoked by themselves
+		if claims.Id == "" {
+			respondError(w, http.StatusBadRequest, errors.New("the token has no jti, use /logout/all to revoke it"))
+			return
+		}
+
+		// revoke the access token
+		if err := s.Revocations().Revoke(r.Context(), claims); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// revoke the session of the token, along with its refresh tokens
+		if claims.SessionId != "" {
+			if _, err := s.Sessions().Revoke(r.Context(), claims.UserId, claims.SessionId); err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+				return
+			}
+		}
+
+		// revoke the family of the refresh token, only if it belongs to the same user
+		if req.RefreshToken != "" {
+			token, err := s.Repository().GetRefreshTokenByHash(r.Context(), hashToken(req.RefreshToken))
+			if err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+				return
+			}
+			if token != nil && token.UserId == claims.UserId {
+				if err := s.Repository().RevokeRefreshTokenFamily(r.Context(), token.FamilyId); err != nil {
+					log.Println(err)
+					respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+					return
+				}
+			}
+		}
+
+		// record the logout
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.logout", ResourceType: audit.ResourceUser, ResourceId: claims.UserId})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LogoutAllHandler is a function that revokes every token of the user, logging it out of all devices
+func LogoutAllHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// revoke every token of the user
+		if err := s.Revocations().RevokeUser(r.Context(), claims.UserId); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the logout
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.logout_all", ResourceType: audit.ResourceUser, ResourceId: claims.UserId})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
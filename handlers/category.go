@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"platzi/go/rest-ws/audit"
 	"platzi/go/rest-ws/models"
-	"platzi/go/rest-ws/repository"
 	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/websocket"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -44,13 +45,24 @@ func InsertCategoryHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// insert the category into the database
-		id, err := repository.InsertCategory(r.Context(), category)
+		id, err := s.Repository().InsertCategory(r.Context(), category)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error inserting category"))
 			return
 		}
 
+		// set the id of the new category
+		category.Id = id
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "category.create", ResourceType: audit.ResourceCategory, ResourceId: strconv.FormatInt(id, 10), After: category})
+
+		// notify the websocket clients
+		if err := s.Hub().Publish(websocket.CategoryTopic(category.Id), websocket.NewEvent(websocket.CategoryCreated, category)); err != nil {
+			log.Println(err)
+		}
+
 		// create a new response
 		res := &InsertCategoryResponse{
 			ID:   id,
@@ -92,7 +104,7 @@ func GetCategoryByIdHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// get the category from the database
-		category, err := repository.GetCategoryById(r.Context(), id)
+		category, err := s.Repository().GetCategoryById(r.Context(), id)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error getting category"))
@@ -162,7 +174,7 @@ func UpdateCategoryHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// get the category from the database
-		category, err := repository.GetCategoryById(r.Context(), id)
+		category, err := s.Repository().GetCategoryById(r.Context(), id)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error getting category"))
@@ -182,23 +194,34 @@ func UpdateCategoryHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// due the name is is a unique field in the database, we need to check if the new name is already in use and return a bad request status if it is
-		cat, _ := repository.GetCategoryByName(r.Context(), req.Name)
+		cat, _ := s.Repository().GetCategoryByName(r.Context(), req.Name)
 		if cat.Name == req.Name {
 			respondError(w, http.StatusBadRequest, errors.New("the new name is already in use"))
 			return
 		}
 
+		// keep the category as it was for the audit log
+		before := *category
+
 		// update the category
 		category.Name = req.Name
 
 		// update the category into the database
-		err = repository.UpdateCategory(r.Context(), category)
+		err = s.Repository().UpdateCategory(r.Context(), category)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error updating category"))
 			return
 		}
 
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "category.update", ResourceType: audit.ResourceCategory, ResourceId: strconv.FormatInt(category.Id, 10), Before: before, After: category})
+
+		// notify the websocket clients
+		if err := s.Hub().Publish(websocket.CategoryTopic(category.Id), websocket.NewEvent(websocket.CategoryUpdated, category)); err != nil {
+			log.Println(err)
+		}
+
 		// create a new response
 		res := &UpdateCategoryResponse{
 			ID:   category.Id,
@@ -235,7 +258,7 @@ func DeleteCategoryHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// get the category from the database
-		category, err := repository.GetCategoryById(r.Context(), id)
+		category, err := s.Repository().GetCategoryById(r.Context(), id)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error getting category"))
@@ -249,13 +272,21 @@ func DeleteCategoryHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// delete the category from the database
-		err = repository.DeleteCategory(r.Context(), id)
+		err = s.Repository().DeleteCategory(r.Context(), id)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error deleting category"))
 			return
 		}
 
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "category.delete", ResourceType: audit.ResourceCategory, ResourceId: strconv.FormatInt(category.Id, 10), Before: category})
+
+		// notify the websocket clients
+		if err := s.Hub().Publish(websocket.CategoryTopic(category.Id), websocket.NewEvent(websocket.CategoryDeleted, category)); err != nil {
+			log.Println(err)
+		}
+
 		// create a new response
 		res := &DeleteCategoryResponse{
 			ID: category.Id,
@@ -295,7 +326,7 @@ func ListCategoriesHandler(s server.Server) http.HandlerFunc {
 		}
 
 		// list categories from the database
-		categories, total, err := repository.ListCategories(r.Context(), page, rowsPerPage)
+		categories, total, err := s.Repository().ListCategories(r.Context(), page, rowsPerPage)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, errors.New("error listing categories"))
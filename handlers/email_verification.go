@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/mail"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/validation"
+	"time"
+)
+
+// VerifyEmailRequest is a struct that represents the request of the VerifyEmailHandler
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResendVerificationEmailRequest is a struct that represents the request of the ResendVerificationEmailHandler
+type ResendVerificationEmailRequest struct {
+	Email string `json:"email"`
+}
+
+// sendEmailVerification is a function that creates an email verification token for a user and emails it
+func sendEmailVerification(r *http.Request, s server.Server, user *models.User) error {
+	// generate the token
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// store the hash of the token
+	err = s.Repository().InsertEmailVerification(r.Context(), &models.EmailVerification{
+		TokenHash: hash,
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(s.Config().EmailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	// build the link of the frontend
+	link := fmt.Sprintf("%s/verify-email?token=%s", s.Config().AppURL, url.QueryEscape(token))
+
+	// send the email
+	return s.Mailer().Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Welcome! Follow this link to verify your email, it expires in %s:\n%s\n\n"+
+			"If you didn't create an account, you can ignore this email.\n", s.Config().EmailVerificationTTL, link),
+	})
+}
+
+// VerifyEmailHandler is a function that marks the email of a user as verified using the token that was emailed to it
+func VerifyEmailHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request
+		var req VerifyEmailRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// get the verification from the database
+		hash := hashToken(req.Token)
+		verification, err := s.Repository().GetEmailVerificationByHash(r.Context(), hash)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the verification exists
+		if verification == nil {
+			respondError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+
+		// mark the verification as used, it fails if it was already used or expired
+		used, err := s.Repository().UseEmailVerification(r.Context(), hash)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !used {
+			respondError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+
+		// mark the email as verified
+		if err := s.Repository().MarkUserEmailVerified(r.Context(), verification.UserId); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change, the user of the token is the actor
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.verify_email", ResourceType: audit.ResourceUser, ResourceId: verification.UserId, ActorId: verification.UserId})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendVerificationEmailHandler is a function that emails a new verification link to a user.
+// It is public so users blocked at login can use it. It always responds the same so it can't be used to find out
+// which emails have an account, and an address only gets one email per resend interval.
+func ResendVerificationEmailHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request
+		var req ResendVerificationEmailRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// validate and normalize the email
+		email, err := validation.NormalizeEmail(req.Email)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// send the email in the background unless the address asked for one recently, whether the address
+		// has an account is only known afterwards. The request outlives the response, so it is not cancelled.
+		if s.VerificationLimiter().Allow(email) {
+			background := r.WithContext(context.WithoutCancel(r.Context()))
+			if !s.Background().Go(func() { resendVerificationEmail(background, s, email) }) {
+				log.Println("A verification email was not sent, the server is shutting down")
+			}
+		}
+
+		// respond that the request was accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// resendVerificationEmail is a function that emails a new verification link to the user of an email, if it exists,
+// isn't verified yet and wasn't sent one recently. It runs after the response, so the errors are only logged.
+func resendVerificationEmail(r *http.Request, s server.Server, email string) {
+	// get the user from the database
+	user, err := s.Repository().GetUserByEmail(r.Context(), email)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// nothing to send if the user doesn't exist or is already verified
+	if user == nil || user.Id == "" || user.EmailVerified {
+		return
+	}
+
+	// get the last verification sent to the user, another instance may have sent it
+	latest, err := s.Repository().GetLatestEmailVerification(r.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// don't flood the inbox of the user, the last email is still valid
+	if latest != nil && time.Now().Before(latest.CreatedAt.Add(s.Config().EmailVerificationResendInterval)) {
+		log.Printf("Verification email of user %s skipped, one was sent recently", user.Id)
+		return
+	}
+
+	// send the email
+	if err := sendEmailVerification(r, s, user); err != nil {
+		log.Println(err)
+	}
+}
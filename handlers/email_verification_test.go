@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"platzi/go/rest-ws/background"
+	"platzi/go/rest-ws/mail"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/ratelimit"
+	"platzi/go/rest-ws/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verificationRepository is an in-memory repository of the users and their verification emails
+type verificationRepository struct {
+	*identityRepository
+	verifications []*models.EmailVerification
+}
+
+func (r *verificationRepository) GetLatestEmailVerification(ctx context.Context, userId string) (*models.EmailVerification, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var latest *models.EmailVerification
+	for _, verification := range r.verifications {
+		if verification.UserId == userId {
+			latest = verification
+		}
+	}
+	return latest, nil
+}
+
+func (r *verificationRepository) InsertEmailVerification(ctx context.Context, verification *models.EmailVerification) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	verification.CreatedAt = time.Now()
+	r.verifications = append(r.verifications, verification)
+	return nil
+}
+
+// mailServer is a server with a repository, an outbox and the runner of the background tasks
+type mailServer struct {
+	repositoryServer
+	outbox  *mail.Outbox
+	runner  *background.Runner
+	limiter *ratelimit.Limiter
+}
+
+func newMailServer(repo *verificationRepository) *mailServer {
+	config := &server.Config{EmailVerificationTTL: time.Hour, EmailVerificationResendInterval: time.Minute}
+	return &mailServer{
+		repositoryServer: repositoryServer{repo: repo, config: config},
+		outbox:           mail.NewOutbox(""),
+		runner:           background.NewRunner(),
+		limiter:          ratelimit.NewLimiter(config.EmailVerificationResendInterval),
+	}
+}
+
+func (s *mailServer) Mailer() mail.Mailer                     { return s.outbox }
+func (s *mailServer) Background() *background.Runner          { return s.runner }
+func (s *mailServer) VerificationLimiter() *ratelimit.Limiter { return s.limiter }
+
+// resend is a function that asks for a verification email and waits for it to be sent
+func resend(t *testing.T, s *mailServer, email string) int {
+	t.Helper()
+
+	// ask for the email
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/verify-email/resend", strings.NewReader(`{"email":"`+email+`"}`))
+	ResendVerificationEmailHandler(s)(w, r)
+
+	// wait for the background send, the runner can't be reused afterwards
+	if err := s.runner.Shutdown(context.Background()); err != nil {
+		t.Fatalf("error waiting for the email: %v", err)
+	}
+	s.runner = background.NewRunner()
+	return w.Code
+}
+
+func TestResendVerificationEmailDoesNotDiscloseAccounts(t *testing.T) {
+	unverified := &models.User{Id: "user-1", Email: "jane@example.com"}
+	verified := &models.User{Id: "user-2", Email: "john@example.com", EmailVerified: true}
+	s := newMailServer(&verificationRepository{identityRepository: newIdentityRepository(unverified, verified)})
+
+	// every address gets the same response, twice in a row
+	for _, email := range []string{"jane@example.com", "john@example.com", "nobody@example.com"} {
+		for i := 0; i < 2; i++ {
+			if status := resend(t, s, email); status != http.StatusAccepted {
+				t.Fatalf("got status %d for %s, want %d", status, email, http.StatusAccepted)
+			}
+		}
+	}
+
+	// only the unverified user got an email, once within the interval
+	messages := s.outbox.Messages()
+	if len(messages) != 1 || messages[0].To != unverified.Email {
+		t.Fatalf("unexpected emails %+v", messages)
+	}
+}
+
+func TestResendVerificationEmailLimitsEveryAddress(t *testing.T) {
+	user := &models.User{Id: "user-1", Email: "jane@example.com"}
+	repo := &verificationRepository{identityRepository: newIdentityRepository()}
+	s := newMailServer(repo)
+
+	// an address without account uses up its interval
+	resend(t, s, "jane@example.com")
+
+	// the account is created with that address, the limit of the address still applies
+	repo.users[user.Id] = user
+	resend(t, s, "jane@example.com")
+	if messages := s.outbox.Messages(); len(messages) != 0 {
+		t.Fatalf("the limit of the address was not applied: %+v", messages)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/gdpr"
+	"platzi/go/rest-ws/server"
+
+	"github.com/gorilla/mux"
+)
+
+// respondExport is a function that exports the data of a user and responds it as a json file
+func respondExport(w http.ResponseWriter, r *http.Request, s server.Server, reference string) {
+	// export the data of the user
+	export, err := gdpr.Export(r.Context(), s.Repository(), reference)
+	if errors.Is(err, gdpr.ErrUserNotFound) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		return
+	}
+
+	// record the access to the personal data
+	audit.Record(r, s.Repository(), audit.Event{Action: "user.export", ResourceType: audit.ResourceUser, ResourceId: export.User.Id})
+
+	// set the headers, the browsers download the export as a file
+	filename := fmt.Sprintf("user-%s-%s.json", export.User.Id, export.ExportedAt.UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// encode the response
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(export)
+}
+
+// ExportMeHandler is a function that responds everything that is stored about the authenticated user
+func ExportMeHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user
+		_, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// respond the export
+		respondExport(w, r, s, user.Id)
+	}
+}
+
+// ExportUserHandler is a function that responds everything that is stored about a user, for the data subject requests
+func ExportUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondExport(w, r, s, mux.Vars(r)["id"])
+	}
+}
+
+// EraseUserHandler is a function that erases a user and everything linked to it right away, without grace period
+func EraseUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok || !notSelf(w, r, user) {
+			return
+		}
+
+		// log out every session before the rows are gone
+		if err := s.Revocations().RevokeUser(r.Context(), user.Id); err != nil {
+			log.Println(err)
+		}
+
+		// erase the user
+		err := gdpr.Erase(r.Context(), s.Repository(), user.Id)
+		if errors.Is(err, gdpr.ErrUserNotFound) {
+			respondError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.erase", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
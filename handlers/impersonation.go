@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/segmentio/ksuid"
+)
+
+// maxImpersonationReasonLength is the maximum length of the reason of an impersonation
+const maxImpersonationReasonLength = 500
+
+// ImpersonateRequest is a struct that represents the request of the ImpersonateUserHandler,
+// the reason is kept in the audit trail
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonateResponse is a struct that represents the response of the ImpersonateUserHandler.
+// The token can't be refreshed, a new impersonation is started once it expires.
+type ImpersonateResponse struct {
+	Token     string         `json:"token"`
+	ExpiresIn int64          `json:"expires_in"`
+	User      SignUpResponse `json:"user"`
+}
+
+// ImpersonateUserHandler is a function that issues a short lived token to an admin to act as another user.
+// The token carries the admin in its act claim, so its requests are audited and the actions only the user
+// may take are forbidden.
+func ImpersonateUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the admin
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// decode the request
+		var req ImpersonateRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// validate the reason
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" || utf8.RuneCountInString(req.Reason) > maxImpersonationReasonLength {
+			respondError(w, http.StatusBadRequest, errors.New("a reason of at most 500 characters is required"))
+			return
+		}
+
+		// get the user
+		user, ok := targetUser(w, r, s)
+		if !ok || !notSelf(w, r, user) {
+			return
+		}
+
+		// admins can't be impersonated, it would hand over their permissions
+		if models.HasRole(user.Role, models.RoleAdmin) {
+			respondError(w, http.StatusForbidden, errors.New("admins can't be impersonated"))
+			return
+		}
+
+		// disabled users can't log in, so they can't be impersonated either
+		if user.Disabled() {
+			respondError(w, http.StatusForbidden, errAccountDisabled)
+			return
+		}
+
+		// generate the jti, it identifies the impersonation in the audit trail
+		jti, err := ksuid.NewRandom()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// record the impersonation before the token exists
+		now := time.Now()
+		expiresAt := now.Add(s.Config().ImpersonationTTL)
+		impersonation := &models.Impersonation{
+			Id:        jti.String(),
+			AdminId:   &claims.UserId,
+			UserId:    user.Id,
+			Reason:    req.Reason,
+			ExpiresAt: expiresAt,
+		}
+		if err := s.Repository().InsertImpersonation(r.Context(), impersonation); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// date the token after the revocations of the user and the admin, both are checked against it
+		issuedAt := s.Revocations().IssuedAt(user.Id)
+		if actorIssuedAt := s.Revocations().IssuedAt(claims.UserId); actorIssuedAt.After(issuedAt) {
+			issuedAt = actorIssuedAt
+		}
+
+		// sign the token with every scope of the user
+		token, err := s.Keys().Sign(models.AppClaims{
+			UserId: user.Id,
+			Role:   user.Role,
+			Scope:  models.FormatScope(models.GrantScopes(user.Role, nil)),
+			Actor:  &models.Actor{UserId: claims.UserId},
+			StandardClaims: jwt.StandardClaims{
+				Id:        jti.String(),
+				IssuedAt:  issuedAt.Unix(),
+				ExpiresAt: expiresAt.Unix(),
+			},
+		})
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// flag the impersonation in the logs
+		log.Printf("Impersonation %s: admin %s started impersonating user %s: %s", jti.String(), claims.UserId, user.Id, req.Reason)
+
+		// record the impersonation
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.impersonate", ResourceType: audit.ResourceUser, ResourceId: user.Id, After: impersonation})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// set the status code
+		w.WriteHeader(http.StatusCreated)
+
+		// encode the response
+		json.NewEncoder(w).Encode(ImpersonateResponse{
+			Token:     token,
+			ExpiresIn: int64(s.Config().ImpersonationTTL.Seconds()),
+			User:      newUserResponse(user),
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"platzi/go/rest-ws/server"
+)
+
+// JWKSHandler is a function that publishes the public keys that verify the tokens
+func JWKSHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// set the headers, the verifiers may cache the keys for a while
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+
+		// encode the keys
+		json.NewEncoder(w).Encode(s.Keys().JWKS())
+	}
+}
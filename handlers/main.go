@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // decode is a function that decodes a request
@@ -29,3 +32,12 @@ func respondError(w http.ResponseWriter, code int, err error) {
 	// write the error
 	w.Write([]byte(err.Error()))
 }
+
+// respondTooManyRequests is a function that responds with an error telling the client how long to wait
+func respondTooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	// set the seconds to wait, rounded up
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	// respond the error
+	respondError(w, http.StatusTooManyRequests, err)
+}
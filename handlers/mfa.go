@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/totp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/segmentio/ksuid"
+)
+
+// Parameters of the second factor
+const (
+	// totpSkew is the number of time steps accepted before and after the current one
+	totpSkew = 1
+
+	// recoveryCodeCount is the number of recovery codes generated when the second factor is confirmed
+	recoveryCodeCount = 10
+)
+
+// EnrollMFARequest is a struct that represents the request of the EnrollMFAHandler
+type EnrollMFARequest struct {
+	Password string `json:"password"`
+}
+
+// EnrollMFAResponse is a struct that represents the response of the EnrollMFAHandler
+type EnrollMFAResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// ConfirmMFARequest is a struct that represents the request of the ConfirmMFAHandler
+type ConfirmMFARequest struct {
+	Code string `json:"code"`
+}
+
+// ConfirmMFAResponse is a struct that represents the response of the ConfirmMFAHandler
+// and the RegenerateRecoveryCodesHandler
+type ConfirmMFAResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableMFARequest is a struct that represents the request of the DisableMFAHandler
+type DisableMFARequest struct {
+	Password string `json:"password"`
+}
+
+// RegenerateRecoveryCodesRequest is a struct that represents the request of the RegenerateRecoveryCodesHandler
+type RegenerateRecoveryCodesRequest struct {
+	Password string `json:"password"`
+}
+
+// MFARequiredResponse is a struct that represents the response of the LoginHandler when the user has a second factor
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// LoginMFARequest is a struct that represents the request of the LoginMFAHandler, it has a code or a recovery code
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// signMFAToken is a function that signs the mfa pending token of a user that entered its password.
+// It keeps the requested scope so the access token is issued as the login asked.
+func signMFAToken(s server.Server, user *models.User, scope string) (string, error) {
+	// generate the jti, it is revoked when the token is exchanged
+	jti, err := ksuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	// create the claims
+	now := time.Now()
+	claims := models.AppClaims{
+		UserId:  user.Id,
+		Scope:   scope,
+		Purpose: models.PurposeMFAPending,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			IssuedAt:  s.Revocations().IssuedAt(user.Id).Unix(),
+			ExpiresAt: now.Add(s.Config().MFAPendingTTL).Unix(),
+		},
+	}
+
+	// sign the token
+	return s.Keys().Sign(claims)
+}
+
+// newRecoveryCodes is a function that generates the recovery codes of a user and the hashes that are stored in their place
+func newRecoveryCodes() ([]string, []string, error) {
+	// define the codes and their hashes
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	// generate every code
+	for i := 0; i < recoveryCodeCount; i++ {
+		// read the random bytes, 50 bits are encoded in 10 characters
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+
+		// split the code in two groups so it is easier to read
+		code := encoded[:5] + "-" + encoded[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	// return the codes and their hashes
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode is a function that returns the hash of a recovery code, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	return hashToken(normalized)
+}
+
+// EnrollMFAHandler is a function that generates a TOTP secret for the authenticated user.
+// The second factor is not required until it is confirmed with a code, enrolling again replaces the secret.
+// The password is checked so a stolen token can't bind another device and lock the user out.
+func EnrollMFAHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user, api keys can't manage the second factor
+		claims, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req EnrollMFARequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the password
+		if !checkPassword(w, r, s, claims, user, req.Password) {
+			return
+		}
+
+		// generate the secret
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// store the secret, it fails if the second factor is already confirmed
+		stored, err := s.Repository().UpsertUserMFA(r.Context(), &models.UserMFA{UserId: user.Id, Secret: secret})
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !stored {
+			respondError(w, http.StatusConflict, errors.New("the second factor is already enabled"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "mfa.enroll", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(EnrollMFAResponse{
+			Secret: secret,
+			URI:    totp.URI(s.Config().MFAIssuer, user.Email, secret),
+		})
+	}
+}
+
+// ConfirmMFAHandler is a function that enables the second factor of the authenticated user with a code of its app.
+// It responds the recovery codes, they are only shown this time.
+func ConfirmMFAHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// api keys can't manage the second factor
+		if claims.ApiKeyId != "" {
+			respondError(w, http.StatusForbidden, errors.New("api keys can't manage the second factor"))
+			return
+		}
+
+		// decode the request
+		var req ConfirmMFARequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// get the second factor of the user
+		mfa, err := s.Repository().GetUserMFA(r.Context(), claims.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the user has enrolled
+		if mfa == nil {
+			respondError(w, http.StatusBadRequest, errors.New("the second factor has not been enrolled"))
+			return
+		}
+		if mfa.Enabled() {
+			respondError(w, http.StatusConflict, errors.New("the second factor is already enabled"))
+			return
+		}
+
+		// check the code
+		step, valid := totp.Validate(mfa.Secret, req.Code, time.Now(), totpSkew)
+		if !valid {
+			respondError(w, http.StatusBadRequest, errors.New("invalid code"))
+			return
+		}
+
+		// generate the recovery codes
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// confirm the second factor, it fails if another request confirmed it first
+		confirmed, err := s.Repository().ConfirmUserMFA(r.Context(), claims.UserId, step, hashes)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !confirmed {
+			respondError(w, http.StatusConflict, errors.New("the second factor is already enabled"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "mfa.confirm", ResourceType: audit.ResourceUser, ResourceId: claims.UserId})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(ConfirmMFAResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableMFAHandler is a function that removes the second factor of the authenticated user and its recovery codes.
+// The password is checked so a stolen token can't remove it.
+func DisableMFAHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user, api keys can't manage the second factor
+		claims, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req DisableMFARequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the password
+		if !checkPassword(w, r, s, claims, user, req.Password) {
+			return
+		}
+
+		// remove the second factor
+		deleted, err := s.Repository().DeleteUserMFA(r.Context(), user.Id)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !deleted {
+			respondError(w, http.StatusNotFound, errors.New("the second factor has not been enrolled"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "mfa.disable", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RegenerateRecoveryCodesHandler is a function that replaces the recovery codes of the authenticated user.
+// The password is checked and the new codes are only shown this time.
+func RegenerateRecoveryCodesHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the authenticated user, api keys can't manage the second factor
+		claims, user, ok := currentUser(w, r, s)
+		if !ok {
+			return
+		}
+
+		// decode the request
+		var req RegenerateRecoveryCodesRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// check the password
+		if !checkPassword(w, r, s, claims, user, req.Password) {
+			return
+		}
+
+		// generate the recovery codes
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// replace the recovery codes, it fails if the second factor is not enabled
+		replaced, err := s.Repository().ReplaceRecoveryCodes(r.Context(), user.Id, hashes)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !replaced {
+			respondError(w, http.StatusConflict, errors.New("the second factor is not enabled"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "mfa.recovery_codes_regenerate", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(ConfirmMFAResponse{RecoveryCodes: codes})
+	}
+}
+
+// LoginMFAHandler is a function that exchanges the mfa pending token of the LoginHandler and a TOTP or recovery code
+// for the access tokens. The pending token is revoked so it can only be exchanged once.
+func LoginMFAHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request
+		var req LoginMFARequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// validate the pending token
+		claims, err := middlewares.ValidatePurposeToken(r.Context(), s, req.MFAToken, models.PurposeMFAPending)
+		if errors.Is(err, middlewares.ErrInvalidToken) {
+			respondError(w, http.StatusUnauthorized, errors.New("invalid or expired mfa token"))
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the codes of the user or the client ip must wait before trying again
+		mfaKey, ipKey := loginguard.MFAKey(claims.UserId), loginguard.IPKey(middlewares.ClientIP(r))
+		if wait, err := s.LoginGuard().Check(r.Context(), mfaKey, ipKey); err != nil {
+			if errors.Is(err, loginguard.ErrLocked) || errors.Is(err, loginguard.ErrThrottled) {
+				respondTooManyRequests(w, wait, err)
+				return
+			}
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// get the second factor of the user
+		mfa, err := s.Repository().GetUserMFA(r.Context(), claims.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !mfa.Enabled() {
+			respondError(w, http.StatusUnauthorized, errors.New("invalid or expired mfa token"))
+			return
+		}
+
+		// check the code, or the recovery code when the user lost its device
+		var accepted bool
+		if req.RecoveryCode != "" {
+			accepted, err = s.Repository().UseRecoveryCode(r.Context(), claims.UserId, hashRecoveryCode(req.RecoveryCode))
+		} else if step, valid := totp.Validate(mfa.Secret, req.Code, time.Now(), totpSkew); valid {
+			// record the step so the same code can't be used again
+			accepted, err = s.Repository().UseTOTPStep(r.Context(), claims.UserId, step)
+		}
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !accepted {
+			if err := s.LoginGuard().Fail(r.Context(), mfaKey, ipKey); err != nil {
+				log.Println(err)
+			}
+			respondError(w, http.StatusUnauthorized, errors.New("invalid code"))
+			return
+		}
+
+		// forget the failures of the codes of the user
+		if err := s.LoginGuard().Succeed(r.Context(), mfaKey); err != nil {
+			log.Println(err)
+		}
+
+		// revoke the pending token
+		if err := s.Revocations().Revoke(r.Context(), claims); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// get the user from the database, its role may have changed since the password was checked
+		user, err := s.Repository().GetUserById(r.Context(), claims.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if user == nil || user.Id == "" {
+			respondError(w, http.StatusUnauthorized, errors.New("invalid or expired mfa token"))
+			return
+		}
+
+		// check if an admin disabled the account while the user entered the code
+		if user.Disabled() {
+			respondError(w, http.StatusForbidden, errAccountDisabled)
+			return
+		}
+
+		// logging in during the grace period restores a deleted account
+		if err := restoreAccount(r, s, user); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// start a new session and issue its tokens with the scope requested at login
+		resp, err := startSession(r, s, user, models.ParseScope(claims.Scope))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// record the login
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.login", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id})
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mfaRepository is an in-memory repository of the second factor of the users
+type mfaRepository struct {
+	*identityRepository
+	mfa           map[string]*models.UserMFA
+	recoveryCodes map[string][]string
+}
+
+func (r *mfaRepository) UpsertUserMFA(ctx context.Context, mfa *models.UserMFA) (bool, error) {
+	if r.mfa[mfa.UserId].Enabled() {
+		return false, nil
+	}
+	r.mfa[mfa.UserId] = mfa
+	return true, nil
+}
+
+func (r *mfaRepository) DeleteUserMFA(ctx context.Context, userId string) (bool, error) {
+	_, ok := r.mfa[userId]
+	delete(r.mfa, userId)
+	delete(r.recoveryCodes, userId)
+	return ok, nil
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) (bool, error) {
+	if !r.mfa[userId].Enabled() {
+		return false, nil
+	}
+	r.recoveryCodes[userId] = codeHashes
+	return true, nil
+}
+
+// guardServer is a server with a repository, a config and a login guard
+type guardServer struct {
+	repositoryServer
+	guard *loginguard.Guard
+}
+
+func (s *guardServer) LoginGuard() *loginguard.Guard {
+	return s.guard
+}
+
+func TestMFAChangesRequirePassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		handler func(s server.Server) http.HandlerFunc
+		status  int
+	}{
+		{name: "enroll", handler: EnrollMFAHandler, status: http.StatusOK},
+		{name: "disable", handler: DisableMFAHandler, status: http.StatusNoContent},
+		{name: "regenerate recovery codes", handler: RegenerateRecoveryCodesHandler, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a user with a confirmed second factor, except when enrolling
+			user := &models.User{Id: "user-1", Email: "jane@example.com", Password: string(hash)}
+			confirmedAt := time.Now()
+			mfa := &models.UserMFA{UserId: user.Id, Secret: "secret", ConfirmedAt: &confirmedAt}
+			if tt.name == "enroll" {
+				mfa.ConfirmedAt = nil
+			}
+			repo := &mfaRepository{
+				identityRepository: newIdentityRepository(user),
+				mfa:                map[string]*models.UserMFA{user.Id: mfa},
+				recoveryCodes:      map[string][]string{user.Id: {"old"}},
+			}
+			s := &guardServer{
+				repositoryServer: repositoryServer{repo: repo, config: &server.Config{MFAIssuer: "rest-ws"}},
+				guard:            loginguard.NewGuard(loginguard.NewMemoryStore(), loginguard.Config{AccountThreshold: 10, Window: time.Minute}),
+			}
+			claims := &models.AppClaims{UserId: user.Id}
+
+			// send the request with a password
+			send := func(password string) int {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/me/mfa", strings.NewReader(`{"password":"`+password+`"}`))
+				tt.handler(s)(w, r.WithContext(middlewares.WithClaims(r.Context(), claims)))
+				return w.Code
+			}
+
+			// a stolen token without the password can't change the second factor
+			if status := send("wrong"); status != http.StatusForbidden {
+				t.Fatalf("got status %d with a wrong password, want %d", status, http.StatusForbidden)
+			}
+			if repo.mfa[user.Id] != mfa || len(repo.recoveryCodes[user.Id]) != 1 || len(repo.events) != 0 {
+				t.Fatal("the second factor changed with a wrong password")
+			}
+
+			// the password confirms the change
+			if status := send("correct horse"); status != tt.status {
+				t.Fatalf("got status %d, want %d", status, tt.status)
+			}
+			if len(repo.events) != 1 {
+				t.Fatalf("unexpected audit events %+v", repo.events)
+			}
+		})
+	}
+}
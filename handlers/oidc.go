@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/oidc"
+	"platzi/go/rest-ws/repository"
+	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/validation"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// oidcStateCookie is the cookie that binds a login at the provider to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// oidcCookiePath is the path of the login with the provider, the cookie is only sent to it
+const oidcCookiePath = "/login/oidc"
+
+// errOIDCDisabled is returned when the login with a provider is not configured
+var errOIDCDisabled = errors.New("oidc login is not configured")
+
+// errInvalidOIDCState is returned when the callback doesn't belong to a login started by the browser
+var errInvalidOIDCState = errors.New("invalid or expired oidc state")
+
+// OIDCLoginHandler is a function that starts a login with the OpenID Connect provider, redirecting the user to it.
+// The optional scope query parameter is the scope of the tokens issued after the callback.
+func OIDCLoginHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// check if the login with a provider is enabled
+		provider := s.OIDC()
+		if provider == nil {
+			respondError(w, http.StatusNotFound, errOIDCDisabled)
+			return
+		}
+
+		// generate the state, only its hash is stored
+		state, stateHash, err := newOpaqueToken()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// generate the nonce, it binds the id token to this login
+		nonce, _, err := newOpaqueToken()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// generate the PKCE verifier
+		verifier, err := oidc.NewCodeVerifier()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// store the login until the callback
+		ttl := s.Config().OIDCLoginTTL
+		err = s.Repository().InsertOIDCLogin(r.Context(), &models.OIDCLogin{
+			StateHash:    stateHash,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			Scope:        models.FormatScope(models.ParseScope(r.URL.Query().Get("scope"))),
+			ExpiresAt:    time.Now().Add(ttl),
+		})
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// get the URL of the provider
+		authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusBadGateway, errors.New("oidc provider unavailable"))
+			return
+		}
+
+		// bind the state to the browser
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     oidcCookiePath,
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		// redirect the user to the provider
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler is a function that finishes a login with the OpenID Connect provider.
+// It verifies the id token, links the account of the provider to a user and issues the tokens like LoginHandler.
+func OIDCCallbackHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// check if the login with a provider is enabled
+		provider := s.OIDC()
+		if provider == nil {
+			respondError(w, http.StatusNotFound, errOIDCDisabled)
+			return
+		}
+
+		// the state cookie is only used once
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath, MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil})
+
+		// check that the callback was started by this browser
+		query := r.URL.Query()
+		state := query.Get("state")
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			respondError(w, http.StatusBadRequest, errInvalidOIDCState)
+			return
+		}
+
+		// get the login, each state can only be used once
+		login, err := s.Repository().ConsumeOIDCLogin(r.Context(), hashToken(state))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if login == nil || time.Now().After(login.ExpiresAt) {
+			respondError(w, http.StatusBadRequest, errInvalidOIDCState)
+			return
+		}
+
+		// check if the user refused or the provider failed
+		if providerErr := query.Get("error"); providerErr != "" {
+			respondError(w, http.StatusUnauthorized, fmt.Errorf("oidc login failed: %s", providerErr))
+			return
+		}
+
+		// exchange the code for the tokens of the provider
+		token, err := provider.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusUnauthorized, errors.New("oidc login failed"))
+			return
+		}
+
+		// verify the id token
+		claims, err := provider.VerifyIDToken(r.Context(), token.IDToken, login.Nonce)
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			log.Println(err)
+			respondError(w, http.StatusUnauthorized, errors.New("oidc login failed"))
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusBadGateway, errors.New("oidc provider unavailable"))
+			return
+		}
+
+		// get the user of the account of the provider
+		user, status, err := oidcUser(r, s, provider.Issuer(), claims)
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				log.Println(err)
+				err = errors.New("internal server error")
+			}
+			respondError(w, status, err)
+			return
+		}
+
+		// check the account and issue the tokens with the scope requested when the login started
+		completeLogin(w, r, s, user, models.ParseScope(login.Scope))
+	}
+}
+
+// oidcUser is a function that returns the user linked to an account of the provider, linking or creating it
+// on the first login. An existing user is only linked by email if the provider verified the email.
+// It returns the status code to respond along with the error.
+func oidcUser(r *http.Request, s server.Server, issuer string, claims *oidc.IDTokenClaims) (*models.User, int, error) {
+	// get the context of the request
+	ctx := r.Context()
+
+	// get the linked identity
+	identity, err := s.Repository().GetUserIdentity(ctx, issuer, claims.Subject)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// get the linked user
+	if identity != nil {
+		user, err := s.Repository().GetUserById(ctx, identity.UserId)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if user == nil || user.Id == "" {
+			return nil, http.StatusUnauthorized, errors.New("oidc login failed")
+		}
+		return user, http.StatusOK, nil
+	}
+
+	// the email identifies the user on the first login
+	email, err := validation.NormalizeEmail(claims.Email)
+	if err != nil {
+		return nil, http.StatusForbidden, errors.New("the oidc provider did not share a valid email")
+	}
+	identity = &models.UserIdentity{Issuer: issuer, Subject: claims.Subject, Email: email}
+
+	// get the user with the email
+	user, err := s.Repository().GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// link the existing user, the provider must prove the email belongs to the account
+	if user != nil && user.Id != "" {
+		if !claims.IsEmailVerified() {
+			return nil, http.StatusConflict, errors.New("an account with this email exists, the oidc provider must verify the email to link it")
+		}
+
+		// link the identity
+		identity.UserId = user.Id
+		if err := s.Repository().InsertUserIdentity(ctx, identity); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		// the provider verified the email
+		if !user.EmailVerified {
+			if err := s.Repository().MarkUserEmailVerified(ctx, user.Id); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+			user.EmailVerified = true
+		}
+
+		// record the change, the user is not authenticated yet
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.identity_link", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id, After: identity})
+
+		// return the user
+		log.Printf("Linked %s identity %s to user %s", issuer, claims.Subject, user.Id)
+		return user, http.StatusOK, nil
+	}
+
+	// generate the id
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// create the user without a password, it can set one with the password reset
+	displayName, err := validation.NormalizeDisplayName(claims.Name)
+	if err != nil {
+		displayName = ""
+	}
+	user = &models.User{
+		Id:            id.String(),
+		Email:         email,
+		Role:          models.RoleViewer,
+		EmailVerified: claims.IsEmailVerified(),
+		DisplayName:   displayName,
+	}
+
+	// insert the user and its identity
+	err = s.Repository().InsertUserWithIdentity(ctx, user, identity)
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return nil, http.StatusConflict, repository.ErrDuplicateEmail
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// record the change, the user is not authenticated yet
+	audit.Record(r, s.Repository(), audit.Event{Action: "user.signup", ResourceType: audit.ResourceUser, ResourceId: user.Id, ActorId: user.Id, After: newUserResponse(user)})
+
+	// return the user
+	return user, http.StatusOK, nil
+}
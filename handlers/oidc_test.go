@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/oidc"
+	"platzi/go/rest-ws/repository"
+	"platzi/go/rest-ws/server"
+	"sync"
+	"testing"
+)
+
+// testIssuer is the issuer of the identities of the tests
+const testIssuer = "https://sso.example.com"
+
+// identityRepository is an in-memory repository of the users and their identities.
+// The methods the tests don't use panic through the nil embedded repository.
+type identityRepository struct {
+	repository.Repository
+
+	mutex      sync.Mutex
+	users      map[string]*models.User
+	identities map[string]*models.UserIdentity
+	events     []*models.AuditEvent
+}
+
+func newIdentityRepository(users ...*models.User) *identityRepository {
+	repo := &identityRepository{users: make(map[string]*models.User), identities: make(map[string]*models.UserIdentity)}
+	for _, user := range users {
+		repo.users[user.Id] = user
+	}
+	return repo
+}
+
+func (r *identityRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.users[id], nil
+}
+
+func (r *identityRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *identityRepository) MarkUserEmailVerified(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.users[id].EmailVerified = true
+	return nil
+}
+
+func (r *identityRepository) GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.identities[issuer+" "+subject], nil
+}
+
+func (r *identityRepository) InsertUserIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.identities[identity.Issuer+" "+identity.Subject] = identity
+	return nil
+}
+
+func (r *identityRepository) InsertUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return repository.ErrDuplicateEmail
+		}
+	}
+	identity.UserId = user.Id
+	r.users[user.Id] = user
+	r.identities[identity.Issuer+" "+identity.Subject] = identity
+	return nil
+}
+
+func (r *identityRepository) InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// repositoryServer is a server that only has a repository and a config
+type repositoryServer struct {
+	server.Server
+	repo   repository.Repository
+	config *server.Config
+}
+
+func (s *repositoryServer) Repository() repository.Repository {
+	return s.repo
+}
+
+func (s *repositoryServer) Config() *server.Config {
+	return s.config
+}
+
+// idTokenClaims is a function that returns the claims of an id token of the test issuer
+func idTokenClaims(t *testing.T, subject, email string, verified bool) *oidc.IDTokenClaims {
+	t.Helper()
+
+	// decode the claims like the provider sends them
+	data, _ := json.Marshal(map[string]interface{}{"sub": subject, "email": email, "email_verified": verified})
+	claims := &oidc.IDTokenClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		t.Fatalf("error decoding claims: %v", err)
+	}
+	return claims
+}
+
+func TestOIDCUserLinksVerifiedEmail(t *testing.T) {
+	existing := &models.User{Id: "user-1", Email: "jane@example.com", Role: models.RoleEditor}
+	repo := newIdentityRepository(existing)
+	s := &repositoryServer{repo: repo}
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+
+	// the provider verified the email of the existing user
+	user, status, err := oidcUser(r, s, testIssuer, idTokenClaims(t, "subject-1", "Jane@Example.com", true))
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d and error %v, want %d", status, err, http.StatusOK)
+	}
+
+	// the identity is linked to the existing user
+	if user.Id != existing.Id {
+		t.Fatalf("got user %s, want %s", user.Id, existing.Id)
+	}
+	identity := repo.identities[testIssuer+" subject-1"]
+	if identity == nil || identity.UserId != existing.Id {
+		t.Fatalf("identity not linked to the user: %+v", identity)
+	}
+	if !existing.EmailVerified {
+		t.Fatal("the email verified by the provider was not marked as verified")
+	}
+	if len(repo.events) != 1 || repo.events[0].Action != "user.identity_link" {
+		t.Fatalf("unexpected audit events %+v", repo.events)
+	}
+}
+
+func TestOIDCUserDoesNotLinkUnverifiedEmail(t *testing.T) {
+	existing := &models.User{Id: "user-1", Email: "jane@example.com", Role: models.RoleEditor}
+	repo := newIdentityRepository(existing)
+	s := &repositoryServer{repo: repo}
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+
+	// the provider didn't verify the email, anyone could have claimed it there
+	_, status, err := oidcUser(r, s, testIssuer, idTokenClaims(t, "subject-1", "jane@example.com", false))
+	if err == nil || status != http.StatusConflict {
+		t.Fatalf("got status %d and error %v, want %d", status, err, http.StatusConflict)
+	}
+
+	// nothing was linked
+	if len(repo.identities) != 0 {
+		t.Fatalf("identity linked with an unverified email: %+v", repo.identities)
+	}
+	if existing.EmailVerified {
+		t.Fatal("the unverified email was marked as verified")
+	}
+}
+
+func TestOIDCUserReturnsLinkedUser(t *testing.T) {
+	existing := &models.User{Id: "user-1", Email: "jane@example.com", Role: models.RoleEditor}
+	repo := newIdentityRepository(existing)
+	repo.identities[testIssuer+" subject-1"] = &models.UserIdentity{Issuer: testIssuer, Subject: "subject-1", UserId: existing.Id}
+	s := &repositoryServer{repo: repo}
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+
+	// the linked identity logs in even if the email changed at the provider
+	user, status, err := oidcUser(r, s, testIssuer, idTokenClaims(t, "subject-1", "other@example.com", false))
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d and error %v, want %d", status, err, http.StatusOK)
+	}
+	if user.Id != existing.Id {
+		t.Fatalf("got user %s, want %s", user.Id, existing.Id)
+	}
+}
+
+func TestOIDCUserCreatesUser(t *testing.T) {
+	repo := newIdentityRepository()
+	s := &repositoryServer{repo: repo}
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+
+	// the first login of an unknown email creates a viewer
+	user, status, err := oidcUser(r, s, testIssuer, idTokenClaims(t, "subject-1", "New@Example.com", true))
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d and error %v, want %d", status, err, http.StatusOK)
+	}
+	if user.Email != "new@example.com" || user.Role != models.RoleViewer || user.Password != "" || !user.EmailVerified {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	identity := repo.identities[testIssuer+" subject-1"]
+	if identity == nil || identity.UserId != user.Id {
+		t.Fatalf("identity not linked to the new user: %+v", identity)
+	}
+}
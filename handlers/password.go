@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/mail"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"platzi/go/rest-ws/validation"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPasswordRequest is a struct that represents the request of the ForgotPasswordHandler
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest is a struct that represents the request of the ResetPasswordHandler
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPasswordHandler is a function that emails a password reset link to a user.
+// It always responds the same so it can't be used to find out which emails have an account.
+func ForgotPasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request
+		var req ForgotPasswordRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// validate and normalize the email
+		email, err := validation.NormalizeEmail(req.Email)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// send the email in the background, neither the status nor the time of the response may
+		// tell if the email has an account. The request outlives the response, so it is not cancelled.
+		background := r.WithContext(context.WithoutCancel(r.Context()))
+		if !s.Background().Go(func() { forgotPassword(background, s, email) }) {
+			log.Println("A password reset was not sent, the server is shutting down")
+		}
+
+		// respond that the request was accepted
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// forgotPassword is a function that emails a password reset link to the user of an email, if it exists and
+// wasn't sent one recently. It runs after the response, so the errors are only logged.
+func forgotPassword(r *http.Request, s server.Server, email string) {
+	// get the user from the database
+	user, err := s.Repository().GetUserByEmail(r.Context(), email)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// only send the email if the user exists
+	if user == nil || user.Id == "" {
+		return
+	}
+
+	// get the last reset sent to the user
+	latest, err := s.Repository().GetLatestPasswordReset(r.Context(), user.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// don't flood the inbox of the user, the last email is still valid
+	if latest != nil && time.Now().Before(latest.CreatedAt.Add(s.Config().PasswordResetResendInterval)) {
+		log.Printf("Password reset of user %s skipped, one was sent recently", user.Id)
+		return
+	}
+
+	// send the email
+	if err := sendPasswordReset(r, s, user); err != nil {
+		log.Println(err)
+		return
+	}
+
+	// record the request, whoever asked for it is unknown
+	audit.Record(r, s.Repository(), audit.Event{Action: "user.password_forgot", ResourceType: audit.ResourceUser, ResourceId: user.Id})
+}
+
+// sendPasswordReset is a function that creates a password reset token for a user and emails it
+func sendPasswordReset(r *http.Request, s server.Server, user *models.User) error {
+	// generate the token
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// store the hash of the token
+	err = s.Repository().InsertPasswordReset(r.Context(), &models.PasswordReset{
+		TokenHash: hash,
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(s.Config().PasswordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	// build the link of the frontend
+	link := fmt.Sprintf("%s/password/reset?token=%s", s.Config().AppURL, url.QueryEscape(token))
+
+	// send the email
+	return s.Mailer().Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account.\n\n"+
+			"Follow this link to choose a new one, it expires in %s:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", s.Config().PasswordResetTTL, link),
+	})
+}
+
+// ResetPasswordHandler is a function that sets a new password using a reset token.
+// The token can only be used once and every session of the user is revoked.
+func ResetPasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// decode the request
+		var req ResetPasswordRequest
+		if err := decode(r.Body, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// get the reset from the database
+		hash := hashToken(req.Token)
+		reset, err := s.Repository().GetPasswordResetByHash(r.Context(), hash)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the reset exists
+		if reset == nil {
+			respondError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+
+		// get the user of the reset
+		user, err := s.Repository().GetUserById(r.Context(), reset.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if user == nil || user.Id == "" {
+			respondError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+
+		// check the new password against the policy, before the token is used so the user can retry
+		if err := s.PasswordPolicy().Validate(req.Password, user.Email); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// mark the reset as used with the other resets of the user, it fails if it was already used or expired
+		used, err := s.Repository().UsePasswordReset(r.Context(), hash)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if !used {
+			respondError(w, http.StatusBadRequest, errors.New("invalid or expired token"))
+			return
+		}
+
+		// hash the password
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// update the password
+		if err := s.Repository().UpdateUserPassword(r.Context(), reset.UserId, string(hashedPassword)); err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// log out every session, they may belong to whoever knew the old password
+		if err := s.Revocations().RevokeUser(r.Context(), reset.UserId); err != nil {
+			log.Println(err)
+		}
+
+		// record the change, the user of the token is the actor
+		audit.Record(r, s.Repository(), audit.Event{Action: "user.password_reset", ResourceType: audit.ResourceUser, ResourceId: reset.UserId, ActorId: reset.UserId})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/audit"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/ksuid"
+)
+
+// SessionResponse is a struct that represents a session in the responses, current marks the session of the request
+type SessionResponse struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+// ListSessionsResponse is a struct that represents the response of the ListSessionsHandler
+type ListSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// startSession is a function that records a new session for the login of a user and issues its tokens.
+// The session id is the family of the refresh tokens, so revoking the session revokes them too.
+func startSession(r *http.Request, s server.Server, user *models.User, requested []string) (*LoginResponse, error) {
+	// generate the id of the session
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	// record the session with the device of the request
+	if _, err := s.Sessions().Start(r.Context(), id.String(), user.Id, r.UserAgent(), middlewares.ClientIP(r)); err != nil {
+		return nil, err
+	}
+
+	// issue the tokens of the session
+	return issueTokens(r.Context(), s, user, id.String(), requested)
+}
+
+// ListSessionsHandler is a function that lists the active sessions of the authenticated user
+func ListSessionsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// get the sessions of the user
+		sessions, err := s.Repository().ListUserSessions(r.Context(), claims.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error listing sessions"))
+			return
+		}
+
+		// mark the session of the request
+		resp := ListSessionsResponse{Sessions: make([]SessionResponse, 0, len(sessions))}
+		for _, session := range sessions {
+			resp.Sessions = append(resp.Sessions, SessionResponse{
+				Session: session,
+				Current: claims.SessionId != "" && session.Id == claims.SessionId,
+			})
+		}
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RevokeSessionHandler is a function that revokes a session of the authenticated user, logging that device out
+func RevokeSessionHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// revoke the session, only the sessions of the user can be revoked
+		revoked, err := s.Sessions().Revoke(r.Context(), claims.UserId, mux.Vars(r)["id"])
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("error revoking session"))
+			return
+		}
+
+		// check if the session was found
+		if !revoked {
+			respondError(w, http.StatusNotFound, errors.New("session not found"))
+			return
+		}
+
+		// record the change
+		audit.Record(r, s.Repository(), audit.Event{Action: "session.revoke", ResourceType: audit.ResourceSession, ResourceId: mux.Vars(r)["id"]})
+
+		// respond without content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
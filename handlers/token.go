@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/server"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/segmentio/ksuid"
+)
+
+// RefreshTokenRequest is a struct that represents the request of the RefreshTokenHandler
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newOpaqueToken is a function that generates a random token and the hash that is stored in its place
+func newOpaqueToken() (string, string, error) {
+	// read the random bytes
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("error generating token: %v", err)
+	}
+
+	// encode the token
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	// return the token and its hash
+	return token, hashToken(token), nil
+}
+
+// hashToken is a function that returns the hash of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signAccessToken is a function that signs a short lived access token of a session for a user with the granted scopes
+func signAccessToken(s server.Server, user *models.User, sessionId string, scopes []string) (string, error) {
+	// generate the jti, it identifies the token when it is revoked
+	jti, err := ksuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	// create the claims
+	now := time.Now()
+	claims := models.AppClaims{
+		UserId:    user.Id,
+		Role:      user.Role,
+		Scope:     models.FormatScope(scopes),
+		SessionId: sessionId,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			IssuedAt:  s.Revocations().IssuedAt(user.Id).Unix(),
+			ExpiresAt: now.Add(s.Config().AccessTokenTTL).Unix(),
+		},
+	}
+
+	// sign the token
+	return s.Keys().Sign(claims)
+}
+
+// issueTokens is a function that issues an access token and a refresh token of the given family for a user.
+// The tokens carry the requested scopes allowed by the role of the user, and the family is the session of the access token.
+func issueTokens(ctx context.Context, s server.Server, user *models.User, familyId string, requested []string) (*LoginResponse, error) {
+	// grant the scopes
+	scopes := models.GrantScopes(user.Role, requested)
+
+	// sign the access token
+	accessToken, err := signAccessToken(s, user, familyId, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	// generate the refresh token
+	refreshToken, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// generate the id of the refresh token
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	// store the hash of the refresh token
+	err = s.Repository().InsertRefreshToken(ctx, &models.RefreshToken{
+		Id:        id.String(),
+		UserId:    user.Id,
+		FamilyId:  familyId,
+		Scope:     models.FormatScope(scopes),
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.Config().RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// return the tokens
+	return &LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.Config().AccessTokenTTL.Seconds()),
+		Scope:        models.FormatScope(scopes),
+	}, nil
+}
+
+// RefreshTokenHandler is a function that exchanges a refresh token for a new pair of tokens.
+// Refresh tokens can only be used once, replaying one revokes its whole family.
+func RefreshTokenHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// define a variable to decode the request into
+		var req RefreshTokenRequest
+
+		// decode the request
+		err := decode(r.Body, &req)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// get the refresh token from the database
+		token, err := s.Repository().GetRefreshTokenByHash(r.Context(), hashToken(req.RefreshToken))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the token exists and is still valid
+		if token == nil || token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+			respondError(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+			return
+		}
+
+		// mark the token as used, it fails if the token was already used
+		fresh := false
+		if token.UsedAt == nil {
+			fresh, err = s.Repository().UseRefreshToken(r.Context(), token.Id)
+			if err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+				return
+			}
+		}
+
+		// a used token is being replayed, the family may be stolen so it is revoked
+		if !fresh {
+			log.Printf("refresh token reuse detected for user %s, revoking family %s", token.UserId, token.FamilyId)
+			if err := s.Repository().RevokeRefreshTokenFamily(r.Context(), token.FamilyId); err != nil {
+				log.Println(err)
+			}
+			respondError(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+			return
+		}
+
+		// get the user, the new access token carries its current role
+		user, err := s.Repository().GetUserById(r.Context(), token.UserId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// check if the user still exists
+		if user == nil || user.Id == "" {
+			respondError(w, http.StatusUnauthorized, errors.New("invalid refresh token"))
+			return
+		}
+
+		// check if an admin disabled the account
+		if user.Disabled() {
+			respondError(w, http.StatusForbidden, errAccountDisabled)
+			return
+		}
+
+		// the families started before the sessions were tracked get their session now
+		current, err := s.Repository().GetSession(r.Context(), token.FamilyId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+		if current == nil {
+			if _, err := s.Sessions().Start(r.Context(), token.FamilyId, user.Id, r.UserAgent(), middlewares.ClientIP(r)); err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+				return
+			}
+		}
+
+		// issue the new tokens in the same family, with the scope granted at login
+		resp, err := issueTokens(r.Context(), s, user, token.FamilyId, models.ParseScope(token.Scope))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, errors.New("internal server error"))
+			return
+		}
+
+		// set the header
+		w.Header().Set("Content-Type", "application/json")
+
+		// encode the response
+		json.NewEncoder(w).Encode(resp)
+	}
+}
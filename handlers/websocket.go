@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/server"
+)
+
+// WebSocketHandler is a function that handles the websocket upgrade of an authenticated user
+func WebSocketHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := middlewares.ClaimsFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, middlewares.ErrInvalidToken)
+			return
+		}
+
+		// check the credentials of the request again while the connection is open, logging out,
+		// revoking the session or disabling the user must close it
+		authorize := func(ctx context.Context) (bool, error) {
+			_, err := middlewares.Authenticate(s, r.WithContext(ctx))
+			if errors.Is(err, middlewares.ErrInvalidToken) {
+				return false, nil
+			}
+			return err == nil, err
+		}
+
+		// upgrade the connection
+		s.Hub().HandleWebSocket(w, r, claims, authorize)
+	}
+}
@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public part of a key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS is a method that returns the public keys of the set, the hmac secrets are never published
+func (s *KeySet) JWKS() JWKS {
+	// define the set, it is never null in the JSON
+	jwks := JWKS{Keys: make([]JWK, 0)}
+
+	// convert every public key
+	for _, key := range s.Keys() {
+		switch public := key.verifying.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	// return the set
+	return jwks
+}
+
+// PublicKey is a method that returns the public key of a JWK, it is meant to verify the tokens of other issuers.
+// RSA, EC and Ed25519 keys are supported.
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		// decode the modulus and the exponent
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding modulus of key %s: %v", k.Kid, err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding exponent of key %s: %v", k.Kid, err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("error decoding exponent of key %s: too large", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		// get the curve
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q of key %s", k.Crv, k.Kid)
+		}
+
+		// decode the point
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding x of key %s: %v", k.Kid, err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding y of key %s: %v", k.Kid, err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("the point of key %s is not on the curve", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		// only the Ed25519 curve signs tokens
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q of key %s", k.Crv, k.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("error decoding x of key %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q of key %s", k.Kty, k.Kid)
+	}
+}
+
+// decodeInt is a function that decodes a base64url big endian integer of a JWK
+func decodeInt(value string) (*big.Int, error) {
+	// decode the bytes
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	// return the integer
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,101 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is a key that signs or verifies tokens, it is identified in the tokens by its id (kid)
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+
+	// signing is nil for the keys that can only verify tokens
+	signing interface{}
+
+	// verifying is the public key, or the secret for the hmac keys
+	verifying interface{}
+}
+
+// CanSign is a method that checks if the key has the private part needed to sign tokens
+func (k *Key) CanSign() bool {
+	return k.signing != nil
+}
+
+// NewHMACKey is a function that creates a HS256 key from a shared secret
+func NewHMACKey(id, secret string) *Key {
+	return &Key{
+		Id:        id,
+		Method:    jwt.SigningMethodHS256,
+		signing:   []byte(secret),
+		verifying: []byte(secret),
+	}
+}
+
+// LoadKeyFile is a function that loads a RSA or Ed25519 key from a PEM file.
+// Private keys sign and verify tokens, public keys only verify them.
+func LoadKeyFile(id, path string) (*Key, error) {
+	// read the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key %s: %v", id, err)
+	}
+
+	// parse the key
+	key, err := ParseKey(id, data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key %s from %s: %v", id, path, err)
+	}
+
+	// return the key
+	return key, nil
+}
+
+// ParseKey is a function that parses a RSA or Ed25519 key from PEM data
+func ParseKey(id string, data []byte) (*Key, error) {
+	// decode the pem block
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	// parse the block according to its type
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// create the key according to its algorithm
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Id: id, Method: jwt.SigningMethodRS256, signing: k, verifying: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{Id: id, Method: jwt.SigningMethodRS256, verifying: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{Id: id, Method: jwt.SigningMethodEdDSA, signing: k, verifying: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{Id: id, Method: jwt.SigningMethodEdDSA, verifying: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 keys are supported", parsed)
+	}
+}
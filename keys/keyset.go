@@ -0,0 +1,97 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrUnknownKey is returned when a token is not signed by any key of the set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet is the set of keys of the server. The signing key signs the new tokens while every key
+// of the set verifies them, so a key that is being rotated out keeps verifying the tokens it signed
+// until they expire.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet is a function that creates a key set that signs with the key of the given id
+func NewKeySet(signingKeyId string, keys ...*Key) (*KeySet, error) {
+	// index the keys by id
+	set := &KeySet{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		if _, ok := set.keys[key.Id]; ok {
+			return nil, fmt.Errorf("duplicated key id %q", key.Id)
+		}
+		set.keys[key.Id] = key
+	}
+
+	// get the signing key
+	signing, ok := set.keys[signingKeyId]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKeyId)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyId)
+	}
+	set.signing = signing
+
+	// return the set
+	return set, nil
+}
+
+// Sign is a method that signs the claims with the signing key, the token header carries its kid
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	// create the token
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+
+	// the key without id is the legacy secret, its tokens have no kid
+	if s.signing.Id != "" {
+		token.Header["kid"] = s.signing.Id
+	}
+
+	// sign the token
+	return token.SignedString(s.signing.signing)
+}
+
+// Keyfunc is a method that returns the key that verifies a token, it is meant for jwt.ParseWithClaims.
+// The token must use the algorithm of its key, so a public key can't be used as a hmac secret.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	// get the kid, tokens without kid are verified by the legacy secret
+	kid, _ := token.Header["kid"].(string)
+
+	// get the key
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// check the algorithm
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+
+	// return the verifying key
+	return key.verifying, nil
+}
+
+// Keys is a method that returns the keys of the set sorted by id
+func (s *KeySet) Keys() []*Key {
+	// collect the keys
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	// sort them so the output is stable
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Id < keys[j].Id
+	})
+
+	// return the keys
+	return keys
+}
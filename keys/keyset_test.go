@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// newRSAKey is a function that generates a RSA key that signs and verifies tokens
+func newRSAKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	return &Key{Id: id, Method: jwt.SigningMethodRS256, signing: private, verifying: &private.PublicKey}
+}
+
+// sign is a function that signs a token with a method, a key and an optional kid
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{Subject: "user-1"})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	return signed
+}
+
+func TestKeyfunc(t *testing.T) {
+	current := newRSAKey(t, "current")
+	rotated := newRSAKey(t, "rotated")
+	rotatedPublic := &Key{Id: rotated.Id, Method: rotated.Method, verifying: rotated.verifying}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	edKey := &Key{Id: "ed", Method: jwt.SigningMethodEdDSA, signing: edPrivate, verifying: edPrivate.Public()}
+	legacy := NewHMACKey("", "secret")
+
+	set, err := NewKeySet("current", current, rotatedPublic, edKey, legacy)
+	if err != nil {
+		t.Fatalf("error creating key set: %v", err)
+	}
+
+	// the public key of the current key, as published in the jwks
+	publicDER, err := x509.MarshalPKIXPublicKey(current.verifying)
+	if err != nil {
+		t.Fatalf("error encoding public key: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "current key", token: sign(t, jwt.SigningMethodRS256, current.signing, "current"), valid: true},
+		{name: "rotated key", token: sign(t, jwt.SigningMethodRS256, rotated.signing, "rotated"), valid: true},
+		{name: "ed25519 key", token: sign(t, jwt.SigningMethodEdDSA, edPrivate, "ed"), valid: true},
+		{name: "legacy secret without kid", token: sign(t, jwt.SigningMethodHS256, []byte("secret"), ""), valid: true},
+		{name: "unknown kid", token: sign(t, jwt.SigningMethodRS256, current.signing, "unknown")},
+		{name: "kid of another key", token: sign(t, jwt.SigningMethodRS256, current.signing, "rotated")},
+		{name: "public key as hmac secret", token: sign(t, jwt.SigningMethodHS256, publicDER, "current")},
+		{name: "legacy secret with the kid of a rsa key", token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "current")},
+		{name: "rsa algorithm for the legacy secret", token: sign(t, jwt.SigningMethodRS256, current.signing, "")},
+		{name: "algorithm none", token: sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "current")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwt.Parse(tt.token, set.Keyfunc)
+			if tt.valid && err != nil {
+				t.Fatalf("token rejected: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsUnknownKid(t *testing.T) {
+	set, err := NewKeySet("current", newRSAKey(t, "current"))
+	if err != nil {
+		t.Fatalf("error creating key set: %v", err)
+	}
+
+	// without the legacy secret, a token without kid has no key either
+	for _, kid := range []string{"unknown", ""} {
+		_, err := set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": kid}})
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("got %v for kid %q, want %v", err, kid, ErrUnknownKey)
+		}
+	}
+}
+
+func TestSignSetsKid(t *testing.T) {
+	key := newRSAKey(t, "current")
+	set, err := NewKeySet("current", key)
+	if err != nil {
+		t.Fatalf("error creating key set: %v", err)
+	}
+
+	// sign a token and parse it back
+	signed, err := set.Sign(jwt.StandardClaims{Subject: "user-1"})
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	token, err := jwt.Parse(signed, set.Keyfunc)
+	if err != nil {
+		t.Fatalf("error parsing token: %v", err)
+	}
+	if token.Header["kid"] != "current" || token.Method.Alg() != "RS256" {
+		t.Fatalf("unexpected header %v", token.Header)
+	}
+}
+
+func TestNewKeySetRejectsVerifyOnlySigningKey(t *testing.T) {
+	key := newRSAKey(t, "current")
+	public := &Key{Id: key.Id, Method: key.Method, verifying: key.verifying}
+	if _, err := NewKeySet("current", public); err == nil {
+		t.Fatal("a public key was accepted as signing key")
+	}
+	if _, err := NewKeySet("current", key, NewHMACKey("current", "secret")); err == nil {
+		t.Fatal("a duplicated kid was accepted")
+	}
+}
@@ -0,0 +1,255 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"platzi/go/rest-ws/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the period between the removals of the stale attempts
+const cleanupInterval = time.Minute
+
+// Errors returned by Check, both come with the time the client has to wait
+var (
+	// ErrLocked is returned while an account or a client ip is locked out
+	ErrLocked = errors.New("too many failed attempts, try again later")
+
+	// ErrThrottled is returned when the client retries before the delay of its last failure
+	ErrThrottled = errors.New("too many attempts, slow down")
+)
+
+// Store is the interface that the stores of the failed attempts must implement
+type Store interface {
+	// GetAttempts returns the attempts of a key, nil if it has none
+	GetAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+
+	// RecordFailure counts a failure of a key at now, restarting the count if the first failure is before windowStart
+	RecordFailure(ctx context.Context, key string, now, windowStart time.Time) (*models.LoginAttempts, error)
+
+	// Lock locks a key out until a time and records the lockout
+	Lock(ctx context.Context, lockout *models.LoginLockout) error
+
+	// Reset forgets the attempts of a key
+	Reset(ctx context.Context, key string) error
+
+	// DeleteStale removes the attempts whose last failure is before a time and that are not locked
+	DeleteStale(ctx context.Context, before time.Time) error
+}
+
+// Config is the config of the guard
+type Config struct {
+	// AccountThreshold is the number of failures of an account within the window that locks it out
+	AccountThreshold int
+
+	// IPThreshold is the number of failures of a client ip within the window that locks it out,
+	// it is higher than the account one since many users may share an ip
+	IPThreshold int
+
+	// Window is the time the failures are counted for
+	Window time.Duration
+
+	// LockoutDuration is the time an account or a client ip is locked out
+	LockoutDuration time.Duration
+
+	// BaseDelay is the delay after the second failure, it doubles with every failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Guard tracks the failed logins per account and per client ip. An account must wait a delay that grows
+// with every failure, and both are locked out when the failures reach their threshold within the window.
+type Guard struct {
+	store   Store
+	config  Config
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewGuard is a function that creates a new guard that keeps the attempts in the store
+func NewGuard(store Store, config Config) *Guard {
+	return &Guard{
+		store:   store,
+		config:  config,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// AccountKey is a function that returns the key of the attempts of an account
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey is a function that returns the key of the attempts of a client ip
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// MFAKey is a function that returns the key of the attempts of the second factor of a user
+func MFAKey(userId string) string {
+	return "mfa:" + userId
+}
+
+// isIPKey is a function that checks if a key is the key of a client ip
+func isIPKey(key string) bool {
+	return strings.HasPrefix(key, "ip:")
+}
+
+// threshold is a method that returns the threshold of a key
+func (g *Guard) threshold(key string) int {
+	if isIPKey(key) {
+		return g.config.IPThreshold
+	}
+	return g.config.AccountThreshold
+}
+
+// delay is a method that returns the time to wait after a number of failures
+func (g *Guard) delay(failures int) time.Duration {
+	// the first failure is free, it is likely a typo
+	if failures < 2 || g.config.BaseDelay <= 0 {
+		return 0
+	}
+
+	// double the delay with every failure
+	delay := g.config.BaseDelay
+	for i := 2; i < failures && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	// cap the delay
+	if g.config.MaxDelay > 0 && delay > g.config.MaxDelay {
+		delay = g.config.MaxDelay
+	}
+
+	// return the delay
+	return delay
+}
+
+// Check is a method that checks if the keys may attempt a login now.
+// It returns ErrLocked or ErrThrottled with the time to wait if one of them may not.
+func (g *Guard) Check(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := time.Now()
+
+	// check every key
+	for _, key := range keys {
+		// get the attempts of the key
+		attempts, err := g.store.GetAttempts(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if attempts == nil {
+			continue
+		}
+
+		// check if the key is locked out
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			return attempts.LockedUntil.Sub(now), ErrLocked
+		}
+
+		// the failures out of the window don't count, and the ips are only locked out
+		// since the users behind the same ip would wait for each other
+		if attempts.FirstFailureAt.Before(now.Add(-g.config.Window)) || isIPKey(key) {
+			continue
+		}
+
+		// check if the delay of the last failure has passed
+		if next := attempts.LastFailureAt.Add(g.delay(attempts.Failures)); now.Before(next) {
+			return next.Sub(now), ErrThrottled
+		}
+	}
+
+	// return nil as the keys may attempt
+	return 0, nil
+}
+
+// Fail is a method that records a failed login of the keys, locking out the ones that reach their threshold
+func (g *Guard) Fail(ctx context.Context, keys ...string) error {
+	now := time.Now()
+
+	// record the failure of every key
+	for _, key := range keys {
+		// count the failure
+		attempts, err := g.store.RecordFailure(ctx, key, now, now.Add(-g.config.Window))
+		if err != nil {
+			return err
+		}
+
+		// check if the key reached its threshold
+		threshold := g.threshold(key)
+		if threshold <= 0 || attempts.Failures < threshold {
+			continue
+		}
+
+		// lock the key out
+		lockout := &models.LoginLockout{
+			Key:         key,
+			Failures:    attempts.Failures,
+			LockedUntil: now.Add(g.config.LockoutDuration),
+		}
+		if err := g.store.Lock(ctx, lockout); err != nil {
+			return err
+		}
+		log.Printf("Login locked out for %s until %s after %d failed attempts", key, lockout.LockedUntil.Format(time.RFC3339), attempts.Failures)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// Succeed is a method that forgets the failures of the keys after a successful login.
+// Only the account keys should be given, so an attacker can't reset the count of its ip with its own account.
+func (g *Guard) Succeed(ctx context.Context, keys ...string) error {
+	// reset every key
+	for _, key := range keys {
+		if err := g.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	// return nil as error
+	return nil
+}
+
+// Run is the cleanup loop of the guard, it must run in its own goroutine until Shutdown is called
+func (g *Guard) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(g.stopped)
+
+	// define a ticker to clean up the store
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// remove the attempts that no longer count
+			if err := g.store.DeleteStale(context.Background(), time.Now().Add(-g.config.Window)); err != nil {
+				log.Println(err)
+			}
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops the cleanup loop
+func (g *Guard) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	g.once.Do(func() {
+		close(g.done)
+	})
+
+	// wait for the loop to finish
+	select {
+	case <-g.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping login guard: %v", ctx.Err())
+	}
+}
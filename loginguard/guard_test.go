@@ -0,0 +1,152 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testConfig is the config of the guards of the tests
+var testConfig = Config{
+	AccountThreshold: 5,
+	IPThreshold:      8,
+	Window:           15 * time.Minute,
+	LockoutDuration:  15 * time.Minute,
+	BaseDelay:        time.Second,
+	MaxDelay:         4 * time.Second,
+}
+
+func TestDelay(t *testing.T) {
+	guard := NewGuard(NewMemoryStore(), testConfig)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 1, want: 0},
+		{failures: 2, want: time.Second},
+		{failures: 3, want: 2 * time.Second},
+		{failures: 4, want: 4 * time.Second},
+		{failures: 5, want: 4 * time.Second},
+		{failures: 50, want: 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := guard.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestCheckThrottlesAndLocksOutTheAccount(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore(), testConfig)
+	account := AccountKey(" Jane@Example.com ")
+
+	// the first failure is free
+	if err := guard.Fail(ctx, account); err != nil {
+		t.Fatalf("error recording failure: %v", err)
+	}
+	if _, err := guard.Check(ctx, AccountKey("jane@example.com")); err != nil {
+		t.Fatalf("got %v after one failure, want nil", err)
+	}
+
+	// the second one makes the account wait
+	if err := guard.Fail(ctx, account); err != nil {
+		t.Fatalf("error recording failure: %v", err)
+	}
+	wait, err := guard.Check(ctx, account)
+	if !errors.Is(err, ErrThrottled) || wait <= 0 || wait > testConfig.BaseDelay {
+		t.Fatalf("got %v and wait %s after two failures, want %v within %s", err, wait, ErrThrottled, testConfig.BaseDelay)
+	}
+
+	// reaching the threshold locks the account out
+	for i := 2; i < testConfig.AccountThreshold; i++ {
+		if err := guard.Fail(ctx, account); err != nil {
+			t.Fatalf("error recording failure: %v", err)
+		}
+	}
+	wait, err = guard.Check(ctx, account)
+	if !errors.Is(err, ErrLocked) || wait <= testConfig.LockoutDuration-time.Minute || wait > testConfig.LockoutDuration {
+		t.Fatalf("got %v and wait %s at the threshold, want %v for %s", err, wait, ErrLocked, testConfig.LockoutDuration)
+	}
+
+	// the lockout is recorded once for the account
+	lockouts := guard.store.(*MemoryStore).Lockouts()
+	if len(lockouts) != 1 || lockouts[0].Key != account {
+		t.Fatalf("unexpected lockouts %+v", lockouts)
+	}
+}
+
+func TestCheckOnlyLocksOutTheIP(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore(), testConfig)
+	ip := IPKey("203.0.113.7")
+
+	// the users behind an ip don't wait for each other
+	for i := 1; i < testConfig.IPThreshold; i++ {
+		if err := guard.Fail(ctx, ip); err != nil {
+			t.Fatalf("error recording failure: %v", err)
+		}
+		if _, err := guard.Check(ctx, ip); err != nil {
+			t.Fatalf("got %v after %d failures of the ip, want nil", err, i)
+		}
+	}
+
+	// the ip is locked out at its threshold
+	if err := guard.Fail(ctx, ip); err != nil {
+		t.Fatalf("error recording failure: %v", err)
+	}
+	if _, err := guard.Check(ctx, ip); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v at the threshold of the ip, want %v", err, ErrLocked)
+	}
+}
+
+func TestSucceedForgetsTheFailures(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore(), testConfig)
+	account := AccountKey("jane@example.com")
+
+	// fail twice and log in
+	for i := 0; i < 2; i++ {
+		if err := guard.Fail(ctx, account); err != nil {
+			t.Fatalf("error recording failure: %v", err)
+		}
+	}
+	if err := guard.Succeed(ctx, account); err != nil {
+		t.Fatalf("error resetting: %v", err)
+	}
+
+	// the account doesn't wait anymore
+	if _, err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("got %v after a successful login, want nil", err)
+	}
+}
+
+func TestCheckIgnoresTheFailuresOutOfTheWindow(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	guard := NewGuard(store, testConfig)
+	account := AccountKey("jane@example.com")
+
+	// failures that started before the window
+	old := time.Now().Add(-2 * testConfig.Window)
+	for i := 0; i < testConfig.AccountThreshold-1; i++ {
+		if _, err := store.RecordFailure(ctx, account, old, old.Add(-testConfig.Window)); err != nil {
+			t.Fatalf("error recording failure: %v", err)
+		}
+	}
+
+	// they don't throttle the account, and a new failure starts the count again
+	if _, err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("got %v for failures out of the window, want nil", err)
+	}
+	if err := guard.Fail(ctx, account); err != nil {
+		t.Fatalf("error recording failure: %v", err)
+	}
+	if _, err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("got %v after the first failure of the window, want nil", err)
+	}
+}
@@ -0,0 +1,118 @@
+package loginguard
+
+import (
+	"context"
+	"platzi/go/rest-ws/models"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the attempts in memory, it fits a single instance and the tests
+type MemoryStore struct {
+	attempts map[string]*models.LoginAttempts
+	lockouts []models.LoginLockout
+	mutex    sync.Mutex
+}
+
+// NewMemoryStore is a function that creates a new empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string]*models.LoginAttempts),
+	}
+}
+
+// GetAttempts is a method that returns a copy of the attempts of a key, nil if it has none
+func (m *MemoryStore) GetAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// look up the key
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	// return a copy so the caller can't change the store
+	copied := *attempts
+	return &copied, nil
+}
+
+// RecordFailure is a method that counts a failure of a key, restarting the count if the first failure is out of the window
+func (m *MemoryStore) RecordFailure(ctx context.Context, key string, now, windowStart time.Time) (*models.LoginAttempts, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// restart the count if the key has no failures within the window
+	attempts, ok := m.attempts[key]
+	if !ok || attempts.FirstFailureAt.Before(windowStart) {
+		// keep the lockout of the previous window
+		restarted := &models.LoginAttempts{Key: key, FirstFailureAt: now}
+		if ok {
+			restarted.LockedUntil = attempts.LockedUntil
+		}
+		attempts = restarted
+		m.attempts[key] = attempts
+	}
+
+	// count the failure
+	attempts.Failures++
+	attempts.LastFailureAt = now
+
+	// return a copy of the attempts
+	copied := *attempts
+	return &copied, nil
+}
+
+// Lock is a method that locks a key out and records the lockout
+func (m *MemoryStore) Lock(ctx context.Context, lockout *models.LoginLockout) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// lock the key, it may have been reset meanwhile
+	attempts, ok := m.attempts[lockout.Key]
+	if !ok {
+		attempts = &models.LoginAttempts{Key: lockout.Key}
+		m.attempts[lockout.Key] = attempts
+	}
+	lockedUntil := lockout.LockedUntil
+	attempts.LockedUntil = &lockedUntil
+
+	// record the lockout
+	lockout.Id = int64(len(m.lockouts) + 1)
+	lockout.CreatedAt = time.Now()
+	m.lockouts = append(m.lockouts, *lockout)
+
+	// return nil as error
+	return nil
+}
+
+// Reset is a method that forgets the attempts of a key
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// DeleteStale is a method that removes the attempts whose last failure is before a time and that are not locked
+func (m *MemoryStore) DeleteStale(ctx context.Context, before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for key, attempts := range m.attempts {
+		if attempts.LastFailureAt.Before(before) && (attempts.LockedUntil == nil || attempts.LockedUntil.Before(now)) {
+			delete(m.attempts, key)
+		}
+	}
+	return nil
+}
+
+// Lockouts is a method that returns the lockouts recorded by the store
+func (m *MemoryStore) Lockouts() []models.LoginLockout {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]models.LoginLockout(nil), m.lockouts...)
+}
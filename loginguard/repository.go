@@ -0,0 +1,43 @@
+package loginguard
+
+import (
+	"context"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"time"
+)
+
+// RepositoryStore keeps the attempts through the repository, so every instance shares the same counters
+type RepositoryStore struct {
+	repo repository.Repository
+}
+
+// NewRepositoryStore is a function that creates a new store backed by the repository
+func NewRepositoryStore(repo repository.Repository) *RepositoryStore {
+	return &RepositoryStore{repo: repo}
+}
+
+// GetAttempts is a method that returns the attempts of a key, nil if it has none
+func (s *RepositoryStore) GetAttempts(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	return s.repo.GetLoginAttempts(ctx, key)
+}
+
+// RecordFailure is a method that counts a failure of a key, restarting the count if the first failure is out of the window
+func (s *RepositoryStore) RecordFailure(ctx context.Context, key string, now, windowStart time.Time) (*models.LoginAttempts, error) {
+	return s.repo.RecordLoginFailure(ctx, key, now, windowStart)
+}
+
+// Lock is a method that locks a key out and records the lockout
+func (s *RepositoryStore) Lock(ctx context.Context, lockout *models.LoginLockout) error {
+	return s.repo.LockLogin(ctx, lockout)
+}
+
+// Reset is a method that forgets the attempts of a key
+func (s *RepositoryStore) Reset(ctx context.Context, key string) error {
+	return s.repo.ResetLoginAttempts(ctx, key)
+}
+
+// DeleteStale is a method that removes the attempts whose last failure is before a time and that are not locked
+func (s *RepositoryStore) DeleteStale(ctx context.Context, before time.Time) error {
+	return s.repo.DeleteStaleLoginAttempts(ctx, before)
+}
@@ -0,0 +1,15 @@
+package mail
+
+import "context"
+
+// Message is a plain text email
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer is the interface that all mail senders must implement
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
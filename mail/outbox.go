@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outbox keeps the messages in memory instead of sending them, and optionally writes each one
+// as a JSON file. It is meant for development and tests.
+type Outbox struct {
+	dir      string
+	messages []Message
+	mutex    sync.Mutex
+}
+
+// NewOutbox is a function that creates an outbox, the messages are also written to dir unless it is empty
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{dir: dir}
+}
+
+// Send is a method that stores a message in the outbox
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	// keep the message in memory
+	o.messages = append(o.messages, msg)
+
+	// check if the messages are written to files
+	if o.dir == "" {
+		return nil
+	}
+
+	// encode the message
+	data, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding mail: %v", err)
+	}
+
+	// write the message, the name keeps the files in the order they were sent
+	name := fmt.Sprintf("%d-%04d.json", time.Now().UnixNano(), len(o.messages))
+	if err := os.WriteFile(filepath.Join(o.dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("error writing mail: %v", err)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// Messages is a method that returns a copy of the messages sent so far
+func (o *Outbox) Messages() []Message {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]Message{}, o.messages...)
+}
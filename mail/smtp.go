@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends the messages through a SMTP server
+type SMTPMailer struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer is a function that creates a mailer for the SMTP server at addr (host:port).
+// The credentials are optional, without them the messages are sent unauthenticated.
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send is a method that sends a message through the SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	// don't send the message if the request was already canceled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the headers can't contain line breaks, they would inject other headers
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("error sending mail to %q: invalid header value", msg.To)
+	}
+
+	// define the authentication
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return fmt.Errorf("error parsing smtp address: %v", err)
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	// build the message
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.from)
+	fmt.Fprintf(&body, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", msg.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	// send the message
+	if err := smtp.SendMail(m.addr, auth, m.from, []string{msg.To}, []byte(body.String())); err != nil {
+		return fmt.Errorf("error sending mail to %s: %v", msg.To, err)
+	}
+
+	// return nil as error
+	return nil
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"log"
 	"os"
+	"os/signal"
 	"platzi/go/rest-ws/handlers"
 	"platzi/go/rest-ws/middlewares"
+	"platzi/go/rest-ws/models"
 	"platzi/go/rest-ws/server"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -22,13 +28,101 @@ func main() {
 	// Get environment variables
 	PORT := os.Getenv("PORT")
 	JWT_SECRET := os.Getenv("JWT_SECRET")
+	JWT_KEYS := keyFilesFromEnv("JWT_KEYS")
+	JWT_SIGNING_KEY := os.Getenv("JWT_SIGNING_KEY")
+	JWT_ACCEPT_LEGACY_SECRET := boolFromEnv("JWT_ACCEPT_LEGACY_SECRET")
 	DATABASE_URL := os.Getenv("DATABASE_URL")
+	SHUTDOWN_TIMEOUT := durationFromEnv("SHUTDOWN_TIMEOUT")
+	SHUTDOWN_HOOK_TIMEOUT := durationFromEnv("SHUTDOWN_HOOK_TIMEOUT")
+	ACCESS_TOKEN_TTL := durationFromEnv("ACCESS_TOKEN_TTL")
+	REFRESH_TOKEN_TTL := durationFromEnv("REFRESH_TOKEN_TTL")
+	REVOCATION_CACHE_TTL := durationFromEnv("REVOCATION_CACHE_TTL")
+	PASSWORD_RESET_TTL := durationFromEnv("PASSWORD_RESET_TTL")
+	PASSWORD_RESET_RESEND_INTERVAL := durationFromEnv("PASSWORD_RESET_RESEND_INTERVAL")
+	REQUIRE_EMAIL_VERIFICATION := boolFromEnv("REQUIRE_EMAIL_VERIFICATION")
+	EMAIL_VERIFICATION_TTL := durationFromEnv("EMAIL_VERIFICATION_TTL")
+	EMAIL_VERIFICATION_RESEND_INTERVAL := durationFromEnv("EMAIL_VERIFICATION_RESEND_INTERVAL")
+	MFA_PENDING_TTL := durationFromEnv("MFA_PENDING_TTL")
+	MFA_ISSUER := os.Getenv("MFA_ISSUER")
+	ACCOUNT_DELETION_GRACE_PERIOD := durationFromEnv("ACCOUNT_DELETION_GRACE_PERIOD")
+	ACCOUNT_PURGE_INTERVAL := durationFromEnv("ACCOUNT_PURGE_INTERVAL")
+	PASSWORD_MIN_LENGTH := intFromEnv("PASSWORD_MIN_LENGTH")
+	PASSWORD_REQUIRE_UPPER := boolFromEnv("PASSWORD_REQUIRE_UPPER")
+	PASSWORD_REQUIRE_LOWER := boolFromEnv("PASSWORD_REQUIRE_LOWER")
+	PASSWORD_REQUIRE_DIGIT := boolFromEnv("PASSWORD_REQUIRE_DIGIT")
+	PASSWORD_REQUIRE_SYMBOL := boolFromEnv("PASSWORD_REQUIRE_SYMBOL")
+	PASSWORD_BLOCKLIST_FILES := listFromEnv("PASSWORD_BLOCKLIST_FILES")
+	LOGIN_ATTEMPT_STORE := os.Getenv("LOGIN_ATTEMPT_STORE")
+	LOGIN_ACCOUNT_THRESHOLD := intFromEnv("LOGIN_ACCOUNT_THRESHOLD")
+	LOGIN_IP_THRESHOLD := intFromEnv("LOGIN_IP_THRESHOLD")
+	LOGIN_WINDOW := durationFromEnv("LOGIN_WINDOW")
+	LOGIN_LOCKOUT_DURATION := durationFromEnv("LOGIN_LOCKOUT_DURATION")
+	LOGIN_BASE_DELAY := durationFromEnv("LOGIN_BASE_DELAY")
+	LOGIN_MAX_DELAY := durationFromEnv("LOGIN_MAX_DELAY")
+	APP_URL := os.Getenv("APP_URL")
+	SMTP_ADDR := os.Getenv("SMTP_ADDR")
+	SMTP_USERNAME := os.Getenv("SMTP_USERNAME")
+	SMTP_PASSWORD := os.Getenv("SMTP_PASSWORD")
+	MAIL_FROM := os.Getenv("MAIL_FROM")
+	MAIL_OUTBOX_DIR := os.Getenv("MAIL_OUTBOX_DIR")
+	OIDC_ISSUER := os.Getenv("OIDC_ISSUER")
+	OIDC_CLIENT_ID := os.Getenv("OIDC_CLIENT_ID")
+	OIDC_CLIENT_SECRET := os.Getenv("OIDC_CLIENT_SECRET")
+	OIDC_REDIRECT_URL := os.Getenv("OIDC_REDIRECT_URL")
+	OIDC_SCOPES := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	OIDC_LOGIN_TTL := durationFromEnv("OIDC_LOGIN_TTL")
+	IMPERSONATION_TTL := durationFromEnv("IMPERSONATION_TTL")
+	REAUTHENTICATION_MAX_AGE := durationFromEnv("REAUTHENTICATION_MAX_AGE")
 
 	// Create new server config
 	config := &server.Config{
-		Port:        PORT,
-		JwtSecret:   JWT_SECRET,
-		DatabaseURL: DATABASE_URL,
+		Port:                            PORT,
+		JwtSecret:                       JWT_SECRET,
+		JwtKeyFiles:                     JWT_KEYS,
+		JwtSigningKey:                   JWT_SIGNING_KEY,
+		JwtAcceptLegacySecret:           JWT_ACCEPT_LEGACY_SECRET,
+		DatabaseURL:                     DATABASE_URL,
+		ShutdownTimeout:                 SHUTDOWN_TIMEOUT,
+		ShutdownHookTimeout:             SHUTDOWN_HOOK_TIMEOUT,
+		AccessTokenTTL:                  ACCESS_TOKEN_TTL,
+		RefreshTokenTTL:                 REFRESH_TOKEN_TTL,
+		RevocationCacheTTL:              REVOCATION_CACHE_TTL,
+		PasswordResetTTL:                PASSWORD_RESET_TTL,
+		PasswordResetResendInterval:     PASSWORD_RESET_RESEND_INTERVAL,
+		RequireEmailVerification:        REQUIRE_EMAIL_VERIFICATION,
+		EmailVerificationTTL:            EMAIL_VERIFICATION_TTL,
+		EmailVerificationResendInterval: EMAIL_VERIFICATION_RESEND_INTERVAL,
+		MFAPendingTTL:                   MFA_PENDING_TTL,
+		MFAIssuer:                       MFA_ISSUER,
+		AccountDeletionGracePeriod:      ACCOUNT_DELETION_GRACE_PERIOD,
+		AccountPurgeInterval:            ACCOUNT_PURGE_INTERVAL,
+		PasswordMinLength:               PASSWORD_MIN_LENGTH,
+		PasswordRequireUpper:            PASSWORD_REQUIRE_UPPER,
+		PasswordRequireLower:            PASSWORD_REQUIRE_LOWER,
+		PasswordRequireDigit:            PASSWORD_REQUIRE_DIGIT,
+		PasswordRequireSymbol:           PASSWORD_REQUIRE_SYMBOL,
+		PasswordBlocklistFiles:          PASSWORD_BLOCKLIST_FILES,
+		LoginAttemptStore:               LOGIN_ATTEMPT_STORE,
+		LoginAccountThreshold:           LOGIN_ACCOUNT_THRESHOLD,
+		LoginIPThreshold:                LOGIN_IP_THRESHOLD,
+		LoginWindow:                     LOGIN_WINDOW,
+		LoginLockoutDuration:            LOGIN_LOCKOUT_DURATION,
+		LoginBaseDelay:                  LOGIN_BASE_DELAY,
+		LoginMaxDelay:                   LOGIN_MAX_DELAY,
+		AppURL:                          APP_URL,
+		SMTPAddr:                        SMTP_ADDR,
+		SMTPUsername:                    SMTP_USERNAME,
+		SMTPPassword:                    SMTP_PASSWORD,
+		MailFrom:                        MAIL_FROM,
+		MailOutboxDir:                   MAIL_OUTBOX_DIR,
+		OIDCIssuer:                      OIDC_ISSUER,
+		OIDCClientId:                    OIDC_CLIENT_ID,
+		OIDCClientSecret:                OIDC_CLIENT_SECRET,
+		OIDCRedirectURL:                 OIDC_REDIRECT_URL,
+		OIDCScopes:                      OIDC_SCOPES,
+		OIDCLoginTTL:                    OIDC_LOGIN_TTL,
+		ImpersonationTTL:                IMPERSONATION_TTL,
+		ReauthenticationMaxAge:          REAUTHENTICATION_MAX_AGE,
 	}
 
 	// Create new server
@@ -37,44 +131,282 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Start server
-	err = s.Start(BindRoutes)
-	if err != nil {
+	// Cancel the context when the process receives SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start server in the background
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start(BindRoutes)
+	}()
+
+	// Wait until the server fails or a signal is received
+	select {
+	case err := <-errCh:
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	// Drain in-flight requests and release the resources
+	log.Println("Shutting down server")
+	if err := s.Shutdown(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 
+	// Wait for the server to stop serving
+	if err := <-errCh; err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// durationFromEnv parses a duration environment variable, it returns zero when it is empty
+// so the server uses its default
+func durationFromEnv(key string) time.Duration {
+	// get the value
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	// parse the value
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	// return the duration
+	return duration
 }
 
-// BindRoutes binds all routes to the router
-func BindRoutes(s server.Server, r *mux.Router) {
-	// Use CheckAuthMiddleware to check if the user is authenticated
-	r.Use(middlewares.CheckAuthMiddleware(s))
+// intFromEnv parses an integer environment variable, it returns zero when it is empty
+// so the server uses its default
+func intFromEnv(key string) int {
+	// get the value
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
 
+	// parse the value
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	// return the number
+	return number
+}
+
+// boolFromEnv parses a boolean environment variable, it returns false when it is empty
+func boolFromEnv(key string) bool {
+	// get the value
+	value := os.Getenv(key)
+	if value == "" {
+		return false
+	}
+
+	// parse the value
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	// return the value
+	return enabled
+}
+
+// listFromEnv parses an environment variable with a list of values separated by commas
+func listFromEnv(key string) []string {
+	// define the list
+	var list []string
+
+	// add every non empty value
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+
+	// return the list
+	return list
+}
+
+// keyFilesFromEnv parses an environment variable with a list of kid=path pairs separated by commas
+func keyFilesFromEnv(key string) map[string]string {
+	// define the map of files
+	files := make(map[string]string)
+
+	// parse every pair
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Fatalf("invalid %s: %q must be kid=path", key, pair)
+		}
+		files[strings.TrimSpace(id)] = strings.TrimSpace(path)
+	}
+
+	// return the files
+	return files
+}
+
+// BindRoutes binds all routes to the router.
+// Every route declares its auth policy, the server doesn't start if one is missing.
+func BindRoutes(s server.Server, r *mux.Router) error {
 	// Bind home handler
-	r.HandleFunc("/", handlers.HomeHandler(s)).Methods("GET")
+	r.Handle("/", middlewares.Public(handlers.HomeHandler(s))).Methods("GET")
 
 	// Bind Signup handler
-	r.HandleFunc("/signup", handlers.SignUpHandler(s)).Methods("POST")
+	r.Handle("/signup", middlewares.Public(handlers.SignUpHandler(s))).Methods("POST")
 
 	// Bind Login handler
-	r.HandleFunc("/login", handlers.LoginHandler(s)).Methods("POST")
+	r.Handle("/login", middlewares.Public(handlers.LoginHandler(s))).Methods("POST")
+
+	// Bind OIDCLogin handler
+	r.Handle("/login/oidc", middlewares.Public(handlers.OIDCLoginHandler(s))).Methods("GET")
+
+	// Bind OIDCCallback handler
+	r.Handle("/login/oidc/callback", middlewares.Public(handlers.OIDCCallbackHandler(s))).Methods("GET")
+
+	// Bind LoginMFA handler, it authenticates with the mfa pending token of the body
+	r.Handle("/login/mfa", middlewares.Public(handlers.LoginMFAHandler(s))).Methods("POST")
+
+	// Bind RefreshToken handler
+	r.Handle("/token/refresh", middlewares.Public(handlers.RefreshTokenHandler(s))).Methods("POST")
+
+	// Bind ForgotPassword handler
+	r.Handle("/password/forgot", middlewares.Public(handlers.ForgotPasswordHandler(s))).Methods("POST")
+
+	// Bind ResetPassword handler
+	r.Handle("/password/reset", middlewares.Public(handlers.ResetPasswordHandler(s))).Methods("POST")
+
+	// Bind VerifyEmail handler
+	r.Handle("/verify-email", middlewares.Public(handlers.VerifyEmailHandler(s))).Methods("POST")
+
+	// Bind ResendVerificationEmail handler
+	r.Handle("/verify-email/resend", middlewares.Public(handlers.ResendVerificationEmailHandler(s))).Methods("POST")
+
+	// Bind JWKS handler
+	r.Handle("/.well-known/jwks.json", middlewares.Public(handlers.JWKSHandler(s))).Methods("GET")
 
 	// Bind Me handler
-	r.HandleFunc("/me", handlers.MeHandler(s)).Methods("GET")
+	r.Handle("/me", middlewares.Authenticated(s, handlers.MeHandler(s))).Methods("GET")
+
+	// Bind UpdateProfile handler
+	r.Handle("/me", middlewares.Authenticated(s, handlers.UpdateProfileHandler(s))).Methods("PATCH")
+
+	// Bind DeleteAccount handler
+	r.Handle("/me", middlewares.OwnerOnly(s, handlers.DeleteAccountHandler(s))).Methods("DELETE")
+
+	// Bind ExportMe handler
+	r.Handle("/me/export", middlewares.Authenticated(s, handlers.ExportMeHandler(s))).Methods("GET")
+
+	// Bind ChangePassword handler
+	r.Handle("/me/password", middlewares.OwnerOnly(s, handlers.ChangePasswordHandler(s))).Methods("PUT")
+
+	// Bind Logout handler
+	r.Handle("/logout", middlewares.Authenticated(s, handlers.LogoutHandler(s))).Methods("POST")
+
+	// Bind LogoutAll handler
+	r.Handle("/logout/all", middlewares.OwnerOnly(s, handlers.LogoutAllHandler(s))).Methods("POST")
+
+	// Bind EnrollMFA handler
+	r.Handle("/me/mfa/enroll", middlewares.OwnerOnly(s, handlers.EnrollMFAHandler(s))).Methods("POST")
+
+	// Bind ConfirmMFA handler
+	r.Handle("/me/mfa/confirm", middlewares.OwnerOnly(s, handlers.ConfirmMFAHandler(s))).Methods("POST")
+
+	// Bind DisableMFA handler
+	r.Handle("/me/mfa", middlewares.OwnerOnly(s, handlers.DisableMFAHandler(s))).Methods("DELETE")
+
+	// Bind RegenerateRecoveryCodes handler
+	r.Handle("/me/mfa/recovery-codes", middlewares.OwnerOnly(s, handlers.RegenerateRecoveryCodesHandler(s))).Methods("POST")
+
+	// Bind ListSessions handler
+	r.Handle("/me/sessions", middlewares.Authenticated(s, handlers.ListSessionsHandler(s))).Methods("GET")
+
+	// Bind RevokeSession handler
+	r.Handle("/me/sessions/{id}", middlewares.OwnerOnly(s, handlers.RevokeSessionHandler(s))).Methods("DELETE")
+
+	// Bind ListApiKeys handler
+	r.Handle("/me/api-keys", middlewares.Authenticated(s, handlers.ListApiKeysHandler(s))).Methods("GET")
+
+	// Bind CreateApiKey handler
+	r.Handle("/me/api-keys", middlewares.OwnerOnly(s, handlers.CreateApiKeyHandler(s))).Methods("POST")
+
+	// Bind RevokeApiKey handler
+	r.Handle("/me/api-keys/{id}", middlewares.OwnerOnly(s, handlers.RevokeApiKeyHandler(s))).Methods("DELETE")
+
+	// Define the policy of the admin endpoints
+	adminUsers := middlewares.Policy{Role: models.RoleAdmin, Scopes: []string{models.ScopeUsersAdmin}}
+
+	// Bind ListUsers handler
+	r.Handle("/admin/users", middlewares.Protect(s, adminUsers, handlers.ListUsersHandler(s))).Methods("GET")
+
+	// Bind GetUser handler
+	r.Handle("/admin/users/{id}", middlewares.Protect(s, adminUsers, handlers.GetUserHandler(s))).Methods("GET")
+
+	// Bind EraseUser handler
+	r.Handle("/admin/users/{id}", middlewares.Protect(s, adminUsers, handlers.EraseUserHandler(s))).Methods("DELETE")
+
+	// Bind ExportUser handler
+	r.Handle("/admin/users/{id}/export", middlewares.Protect(s, adminUsers, handlers.ExportUserHandler(s))).Methods("GET")
+
+	// Bind DisableUser handler
+	r.Handle("/admin/users/{id}/disable", middlewares.Protect(s, adminUsers, handlers.DisableUserHandler(s))).Methods("POST")
+
+	// Bind EnableUser handler
+	r.Handle("/admin/users/{id}/enable", middlewares.Protect(s, adminUsers, handlers.EnableUserHandler(s))).Methods("POST")
+
+	// Bind UpdateUserRole handler
+	r.Handle("/admin/users/{id}/role", middlewares.Protect(s, adminUsers, handlers.UpdateUserRoleHandler(s))).Methods("PUT")
+
+	// Bind ForcePasswordReset handler
+	r.Handle("/admin/users/{id}/password-reset", middlewares.Protect(s, adminUsers, handlers.ForcePasswordResetHandler(s))).Methods("POST")
+
+	// Define the policy of the impersonation
+	adminImpersonate := middlewares.Policy{Role: models.RoleAdmin, Scopes: []string{models.ScopeUsersImpersonate}}
+
+	// Bind ImpersonateUser handler
+	r.Handle("/admin/users/{id}/impersonate", middlewares.Protect(s, adminImpersonate, handlers.ImpersonateUserHandler(s))).Methods("POST")
+
+	// Define the policy of the audit log
+	adminAudit := middlewares.Policy{Role: models.RoleAdmin, Scopes: []string{models.ScopeAuditRead}}
+
+	// Bind ListAuditEvents handler
+	r.Handle("/admin/audit", middlewares.Protect(s, adminAudit, handlers.ListAuditEventsHandler(s))).Methods("GET")
+
+	// Define the policies of the categories
+	readCategories := middlewares.Policy{Scopes: []string{models.ScopeCategoriesRead}}
+	writeCategories := middlewares.Policy{Role: models.RoleEditor, Scopes: []string{models.ScopeCategoriesWrite}}
+	deleteCategories := middlewares.Policy{Role: models.RoleAdmin, Scopes: []string{models.ScopeCategoriesWrite}}
 
 	// Bind ListCategories handler
-	r.HandleFunc("/categories", handlers.ListCategoriesHandler(s)).Methods("GET")
+	r.Handle("/categories", middlewares.Protect(s, readCategories, handlers.ListCategoriesHandler(s))).Methods("GET")
 
-	// Bind InsertCategory handler
-	r.HandleFunc("/categories", handlers.InsertCategoryHandler(s)).Methods("POST")
+	// Bind InsertCategory handler, only editors may create categories
+	r.Handle("/categories", middlewares.Protect(s, writeCategories, handlers.InsertCategoryHandler(s))).Methods("POST")
 
 	// Bind GetCategoryById handler
-	r.HandleFunc("/categories/{id:[0-9]+}", handlers.GetCategoryByIdHandler(s)).Methods("GET")
+	r.Handle("/categories/{id:[0-9]+}", middlewares.Protect(s, readCategories, handlers.GetCategoryByIdHandler(s))).Methods("GET")
+
+	// Bind UpdateCategory handler, only editors may rename categories
+	r.Handle("/categories/{id:[0-9]+}", middlewares.Protect(s, writeCategories, handlers.UpdateCategoryHandler(s))).Methods("PUT")
 
-	// Bind UpdateCategory handler
-	r.HandleFunc("/categories/{id:[0-9]+}", handlers.UpdateCategoryHandler(s)).Methods("PUT")
+	// Bind DeleteCategory handler, only admins may delete categories
+	r.Handle("/categories/{id:[0-9]+}", middlewares.Protect(s, deleteCategories, handlers.DeleteCategoryHandler(s))).Methods("DELETE")
 
-	// Bind DeleteCategory handler
-	r.HandleFunc("/categories/{id:[0-9]+}", handlers.DeleteCategoryHandler(s)).Methods("DELETE")
+	// Bind websocket handler
+	r.Handle("/ws", middlewares.Authenticated(s, handlers.WebSocketHandler(s))).Methods("GET")
 
+	// Check that no route was bound without policy
+	return middlewares.CheckRoutePolicies(r)
 }
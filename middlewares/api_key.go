@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"time"
+)
+
+// ApiKeyHeader is the header that carries the api keys
+const ApiKeyHeader = "X-API-Key"
+
+// apiKeyTouchInterval is the minimum time between two updates of the last use of an api key
+const apiKeyTouchInterval = time.Minute
+
+// HashApiKey is a function that returns the hash under which an api key is stored
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateApiKey is a function that validates an api key and returns the claims of its user
+func authenticateApiKey(ctx context.Context, repo repository.Repository, key string) (*models.AppClaims, error) {
+	// get the api key from the database
+	apiKey, err := repo.GetApiKeyByHash(ctx, HashApiKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	// check if the key exists and is still valid
+	now := time.Now()
+	if apiKey == nil || apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidToken
+	}
+
+	// get the user of the key, the claims carry its current role
+	user, err := repo.GetUserById(ctx, apiKey.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Id == "" {
+		return nil, ErrInvalidToken
+	}
+
+	// the keys of a disabled account or an account waiting to be deleted stop working, like its tokens
+	if user.Disabled() || user.DeletionScheduledAt != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// record the use of the key, at most once per interval
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := repo.TouchApiKey(ctx, apiKey.Id, now); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// return the claims of the user, a key without scopes gets every scope of the role
+	return &models.AppClaims{
+		UserId:   user.Id,
+		Role:     user.Role,
+		Scope:    models.FormatScope(models.GrantScopes(user.Role, apiKey.Scopes)),
+		ApiKeyId: apiKey.Id,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"testing"
+	"time"
+)
+
+// apiKeyRepository is a repository that holds a single api key and its user
+type apiKeyRepository struct {
+	repository.Repository
+	apiKey  *models.ApiKey
+	user    *models.User
+	touched bool
+}
+
+func (r *apiKeyRepository) GetApiKeyByHash(ctx context.Context, keyHash string) (*models.ApiKey, error) {
+	if r.apiKey == nil || r.apiKey.KeyHash != keyHash {
+		return nil, nil
+	}
+	return r.apiKey, nil
+}
+
+func (r *apiKeyRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
+	if r.user == nil || r.user.Id != id {
+		return &models.User{}, nil
+	}
+	return r.user, nil
+}
+
+func (r *apiKeyRepository) TouchApiKey(ctx context.Context, id string, usedAt time.Time) error {
+	r.touched = true
+	return nil
+}
+
+func TestAuthenticateApiKey(t *testing.T) {
+	const key = "rk_secret"
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	// newKey and newUser return a valid key and its user, the cases change them
+	newKey := func() *models.ApiKey {
+		return &models.ApiKey{Id: "key", UserId: "user", KeyHash: HashApiKey(key), Scopes: []string{models.ScopeCategoriesRead}}
+	}
+	newUser := func() *models.User {
+		return &models.User{Id: "user", Role: models.RoleEditor}
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		change func(apiKey *models.ApiKey, user *models.User) *models.User
+		err    error
+	}{
+		{name: "valid key", key: key},
+		{name: "unknown key", key: "rk_other", err: ErrInvalidToken},
+		{name: "revoked key", key: key, err: ErrInvalidToken, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			apiKey.RevokedAt = &past
+			return user
+		}},
+		{name: "expired key", key: key, err: ErrInvalidToken, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			apiKey.ExpiresAt = &past
+			return user
+		}},
+		{name: "key not expired yet", key: key, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			apiKey.ExpiresAt = &future
+			return user
+		}},
+		{name: "deleted user", key: key, err: ErrInvalidToken, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			return nil
+		}},
+		{name: "disabled user", key: key, err: ErrInvalidToken, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			user.DisabledAt = &past
+			return user
+		}},
+		{name: "user waiting to be deleted", key: key, err: ErrInvalidToken, change: func(apiKey *models.ApiKey, user *models.User) *models.User {
+			user.DeletionScheduledAt = &future
+			return user
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// build the repository of the case
+			repo := &apiKeyRepository{apiKey: newKey(), user: newUser()}
+			if tt.change != nil {
+				repo.user = tt.change(repo.apiKey, repo.user)
+			}
+
+			// authenticate the key
+			claims, err := authenticateApiKey(context.Background(), repo, tt.key)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if claims != nil || repo.touched {
+					t.Errorf("got claims %+v and touched %t for a rejected key", claims, repo.touched)
+				}
+				return
+			}
+
+			// the claims carry the user, its role and the scopes of the key
+			if claims.UserId != "user" || claims.Role != models.RoleEditor || claims.ApiKeyId != "key" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+			if !claims.HasScopes(models.ScopeCategoriesRead) || !repo.touched {
+				t.Errorf("got scope %q and touched %t, want %q and true", claims.Scope, repo.touched, models.ScopeCategoriesRead)
+			}
+		})
+	}
+}
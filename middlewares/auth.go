@@ -1,74 +1,168 @@
 package middlewares
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 	"platzi/go/rest-ws/models"
 	"platzi/go/rest-ws/server"
 	"strings"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
 )
 
-// NO_AUTH_NEEDED is a list of paths that don't need authentication
-var (
-	NO_AUTH_NEEDED = []string{"/", "/signup", "/login"}
-)
+// WebSocketTokenProtocol is the subprotocol a browser client offers before the token in the
+// Sec-WebSocket-Protocol header, since browsers can't set the Authorization header on a websocket
+const WebSocketTokenProtocol = "access_token"
+
+// ErrInvalidToken is returned when the request has no valid token
+var ErrInvalidToken = errors.New("invalid token")
+
+// bearerPrefix is the scheme of the authorization header, the raw token is accepted too
+const bearerPrefix = "bearer "
+
+// tokenFromRequest is a function that gets the token from the request.
+// Websocket upgrades may also send it in the Sec-WebSocket-Protocol header or the access_token query parameter.
+func tokenFromRequest(r *http.Request) string {
+	// get the token from the authorization header, removing the bearer scheme
+	if tokenString := strings.TrimSpace(r.Header.Get("Authorization")); tokenString != "" {
+		if len(tokenString) > len(bearerPrefix) && strings.EqualFold(tokenString[:len(bearerPrefix)], bearerPrefix) {
+			return strings.TrimSpace(tokenString[len(bearerPrefix):])
+		}
+		return tokenString
+	}
+
+	// the other sources are only accepted on websocket upgrades
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+
+	// get the token from the subprotocols, it follows the access_token protocol
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == WebSocketTokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	// get the token from the query
+	return strings.TrimSpace(r.URL.Query().Get("access_token"))
+}
+
+// ValidateToken is a function that validates an access token, checks that neither it nor its session
+// have been revoked and returns its claims.
+// It returns ErrInvalidToken if the token can't be used.
+func ValidateToken(ctx context.Context, s server.Server, tokenString string) (*models.AppClaims, error) {
+	return ValidatePurposeToken(ctx, s, tokenString, "")
+}
+
+// ValidatePurposeToken is a function that validates a token issued for a purpose, like the mfa pending tokens.
+// A token is only accepted for the purpose it was issued, so they can't be used as access tokens.
+func ValidatePurposeToken(ctx context.Context, s server.Server, tokenString, purpose string) (*models.AppClaims, error) {
+	// check if the token is empty
+	if tokenString == "" {
+		return nil, ErrInvalidToken
+	}
+
+	// parse the token
+	token, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, s.Keys().Keyfunc)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// get the claims
+	claims, ok := token.Claims.(*models.AppClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// check the purpose of the token
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+
+	// check if the token has been revoked
+	revoked, err := s.Revocations().IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
 
-// shouldCheckToken is a function that checks if the token should be checked
-func shouldCheckToken(route string) bool {
-	// check if the route is in the list of routes that don't need authentication
-	for _, path := range NO_AUTH_NEEDED {
-		// if the route is in the list, return false
-		if path == route {
-			return false
+	// check if the session of the token has been revoked
+	active, err := s.Sessions().IsActive(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrInvalidToken
+	}
+
+	// check that the admin impersonating the user may still do it
+	if claims.Impersonated() {
+		if err := checkActor(ctx, s, claims); err != nil {
+			return nil, err
 		}
 	}
 
-	// return true if the route is not in the list
-	return true
+	// return the claims
+	return claims, nil
+}
+
+// Authenticate is a function that validates the api key or the token of the request and returns its claims.
+// The tokens of a disabled user are rejected because disabling a user revokes them.
+func Authenticate(s server.Server, r *http.Request) (*models.AppClaims, error) {
+	// api keys take precedence over the tokens
+	if key := strings.TrimSpace(r.Header.Get(ApiKeyHeader)); key != "" {
+		return authenticateApiKey(r.Context(), s.Repository(), key)
+	}
+
+	// validate the token
+	return ValidateToken(r.Context(), s, tokenFromRequest(r))
 }
 
-// CheckAuthMiddleware is a middleware that checks if the user is authenticated
+// CheckAuthMiddleware is a middleware that checks if the user is authenticated.
+// Routes apply it through their policy, see Protect.
 func CheckAuthMiddleware(s server.Server) func(h http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// check if the token should be checked
-			if !shouldCheckToken(r.URL.Path) {
-				// if the token should not be checked, call the next handler
-				next.ServeHTTP(w, r)
-
-				// don't need to continue with the execution
-				return
-			}
+			// validate the token
+			claims, err := Authenticate(s, r)
 
-			// get the token from the request
-			tokenString := strings.TrimSpace(r.Header.Get("Authorization"))
-
-			// check if the token is empty
-			if tokenString == "" {
-				// if the token is empty, return an error
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			// check if the token could not be checked
+			if err != nil && !errors.Is(err, ErrInvalidToken) {
+				// log the error and return an error
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 
 				// don't need to continue with the execution
 				return
 			}
 
-			// validate the token
-			_, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(t *jwt.Token) (interface{}, error) {
-				return []byte(s.Config().JwtSecret), nil
-			})
-
-			// check if there was an error
+			// check if the token is not valid
 			if err != nil {
-				// if there was an error, return an error
+				// if the token is not valid, return an error
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 
 				// don't need to continue with the execution
 				return
 			}
 
-			// if there was no error, call the next handler
-			next.ServeHTTP(w, r)
+			// flag and record the requests of an admin impersonating the user
+			if claims.Impersonated() {
+				if err := recordImpersonation(r, s.Repository(), claims); err != nil {
+					// the request is refused if it can't be audited
+					log.Println(err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			// if there was no error, call the next handler with the claims in the context
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
 		})
 	}
 }
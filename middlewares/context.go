@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"context"
+	"platzi/go/rest-ws/models"
+)
+
+// contextKey is the type of the keys stored by the middlewares in the request context
+type contextKey string
+
+// claimsKey is the key of the authenticated claims in the request context
+const claimsKey contextKey = "claims"
+
+// WithClaims is a function that returns a copy of the context holding the claims
+func WithClaims(ctx context.Context, claims *models.AppClaims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext is a function that returns the claims stored by CheckAuthMiddleware,
+// ok is false if the request was not authenticated
+func ClaimsFromContext(ctx context.Context) (*models.AppClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*models.AppClaims)
+	return claims, ok && claims != nil
+}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"platzi/go/rest-ws/server"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ClientIP is a function that returns the ip of the client of a request.
+// It is the address of the connection, the forwarded headers can be set by anyone.
+func ClientIP(r *http.Request) string {
+	// remove the port
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	// return the host
+	return host
+}
+
+// checkActor is a function that checks that the admin of an impersonation token is still an enabled admin whose
+// tokens have not been revoked, the token carries the user impersonated so revoking the admin doesn't reach it.
+// It returns ErrInvalidToken otherwise.
+func checkActor(ctx context.Context, s server.Server, claims *models.AppClaims) error {
+	// check if the tokens of the admin issued before the impersonation have been revoked
+	revoked, err := s.Revocations().IsRevoked(ctx, &models.AppClaims{UserId: claims.Actor.UserId, StandardClaims: jwt.StandardClaims{IssuedAt: claims.IssuedAt}})
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrInvalidToken
+	}
+
+	// get the admin
+	admin, err := s.Repository().GetUserById(ctx, claims.Actor.UserId)
+	if err != nil {
+		return err
+	}
+
+	// check that the admin still exists, is enabled and is still an admin
+	if admin == nil || admin.Id == "" || admin.Disabled() || !models.HasRole(admin.Role, models.RoleAdmin) {
+		return ErrInvalidToken
+	}
+
+	// return nil as error
+	return nil
+}
+
+// recordImpersonation is a function that logs a request made by an admin impersonating a user and
+// records it in the audit trail of the impersonation
+func recordImpersonation(r *http.Request, repo repository.Repository, claims *models.AppClaims) error {
+	// flag the request in the logs
+	log.Printf("Impersonation %s: admin %s as user %s: %s %s", claims.Id, claims.Actor.UserId, claims.UserId, r.Method, r.URL.Path)
+
+	// record the request
+	return repo.InsertImpersonationRequest(r.Context(), &models.ImpersonationRequest{
+		ImpersonationId: claims.Id,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		IP:              ClientIP(r),
+	})
+}
+
+// RejectImpersonation is a middleware that forbids the request when an admin is impersonating the user,
+// it protects the actions only the user may take. It must run after CheckAuthMiddleware.
+func RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// get the claims of the authenticated user
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// check if the token was issued for an impersonation
+		if claims.Impersonated() {
+			http.Error(w, "Forbidden: not allowed while impersonating", http.StatusForbidden)
+			return
+		}
+
+		// call the next handler
+		next.ServeHTTP(w, r)
+	})
+}
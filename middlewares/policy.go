@@ -0,0 +1,116 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"platzi/go/rest-ws/server"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Policy is the authentication requirement declared by a route when it is registered
+type Policy struct {
+	// Public routes don't need authentication
+	Public bool
+
+	// Role is the role the user needs, empty for any authenticated user
+	Role string
+
+	// Scopes are the scopes the token needs, on top of the role
+	Scopes []string
+
+	// DenyImpersonation forbids the route to the admins impersonating a user
+	DenyImpersonation bool
+}
+
+// policyHandler is a handler that enforces the policy of its route
+type policyHandler struct {
+	http.Handler
+	policy Policy
+}
+
+// Protect is a function that wraps the handler of a route with the middlewares of its policy
+func Protect(s server.Server, policy Policy, h http.Handler) http.Handler {
+	// public routes are served as they are
+	if policy.Public {
+		return &policyHandler{Handler: h, policy: policy}
+	}
+
+	// check the impersonation last
+	if policy.DenyImpersonation {
+		h = RejectImpersonation(h)
+	}
+
+	// check the scopes after the role
+	if len(policy.Scopes) > 0 {
+		h = RequireScopes(policy.Scopes...)(h)
+	}
+
+	// check the role after the authentication
+	if policy.Role != "" {
+		h = RequireRole(policy.Role)(h)
+	}
+
+	// authenticate the request first
+	return &policyHandler{Handler: CheckAuthMiddleware(s)(h), policy: policy}
+}
+
+// Public is a function that declares a route that doesn't need authentication
+func Public(h http.Handler) http.Handler {
+	return Protect(nil, Policy{Public: true}, h)
+}
+
+// Authenticated is a function that declares a route for any authenticated user
+func Authenticated(s server.Server, h http.Handler) http.Handler {
+	return Protect(s, Policy{}, h)
+}
+
+// OwnerOnly is a function that declares a route for any authenticated user acting on its own behalf,
+// the admins impersonating the user are forbidden
+func OwnerOnly(s server.Server, h http.Handler) http.Handler {
+	return Protect(s, Policy{DenyImpersonation: true}, h)
+}
+
+// WithRole is a function that declares a route for the authenticated users with the role
+func WithRole(s server.Server, role string, h http.Handler) http.Handler {
+	return Protect(s, Policy{Role: role}, h)
+}
+
+// CheckRoutePolicies is a function that checks that every route of the router has declared its policy,
+// so a route can't be exposed by forgetting to protect it
+func CheckRoutePolicies(r *mux.Router) error {
+	// define a slice to collect the routes without policy
+	var missing []string
+
+	// walk every route
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		// subrouters have no handler, their routes are walked too
+		handler := route.GetHandler()
+		if handler == nil {
+			return nil
+		}
+
+		// check if the handler has a policy
+		if _, ok := handler.(*policyHandler); ok {
+			return nil
+		}
+
+		// describe the route
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		missing = append(missing, strings.TrimSpace(strings.Join(methods, ",")+" "+path))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking routes at CheckRoutePolicies: %v", err)
+	}
+
+	// fail if any route has no policy
+	if len(missing) > 0 {
+		return fmt.Errorf("routes without auth policy: %s", strings.Join(missing, ", "))
+	}
+
+	// return nil as error
+	return nil
+}
@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"platzi/go/rest-ws/models"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// okHandler is a handler that always responds 200
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestCheckRoutePolicies(t *testing.T) {
+	// every route declares its policy
+	r := mux.NewRouter()
+	r.Handle("/", Public(okHandler)).Methods("GET")
+	r.Handle("/me", Authenticated(nil, okHandler)).Methods("GET")
+	r.Handle("/me", OwnerOnly(nil, okHandler)).Methods("DELETE")
+	r.Handle("/admin/users", WithRole(nil, models.RoleAdmin, okHandler)).Methods("GET")
+	api := r.PathPrefix("/api").Subrouter()
+	api.Handle("/categories", Protect(nil, Policy{Scopes: []string{models.ScopeCategoriesRead}}, okHandler)).Methods("GET")
+	if err := CheckRoutePolicies(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// routes without policy are reported, also in the subrouters
+	r.Handle("/open", okHandler).Methods("GET")
+	api.Handle("/hidden", okHandler).Methods("POST")
+	err := CheckRoutePolicies(r)
+	if err == nil {
+		t.Fatal("routes without policy were not reported")
+	}
+	for _, route := range []string{"GET /open", "POST /api/hidden"} {
+		if !strings.Contains(err.Error(), route) {
+			t.Errorf("error %q doesn't report %s", err, route)
+		}
+	}
+}
+
+func TestPolicyMiddlewares(t *testing.T) {
+	viewer := &models.AppClaims{UserId: "user-1", Role: models.RoleViewer, Scope: models.ScopeCategoriesRead}
+	admin := &models.AppClaims{UserId: "user-2", Role: models.RoleAdmin, Scope: models.ScopeUsersAdmin}
+	impersonated := &models.AppClaims{UserId: "user-1", Role: models.RoleViewer, Actor: &models.Actor{UserId: "user-2"}}
+
+	tests := []struct {
+		name       string
+		middleware func(h http.Handler) http.Handler
+		claims     *models.AppClaims
+		status     int
+	}{
+		{name: "role granted", middleware: RequireRole(models.RoleViewer), claims: admin, status: http.StatusOK},
+		{name: "role missing", middleware: RequireRole(models.RoleAdmin), claims: viewer, status: http.StatusForbidden},
+		{name: "role without claims", middleware: RequireRole(models.RoleViewer), status: http.StatusUnauthorized},
+		{name: "scope granted", middleware: RequireScopes(models.ScopeCategoriesRead), claims: viewer, status: http.StatusOK},
+		{name: "scope missing", middleware: RequireScopes(models.ScopeCategoriesRead), claims: admin, status: http.StatusForbidden},
+		{name: "one of the scopes missing", middleware: RequireScopes(models.ScopeCategoriesRead, models.ScopeCategoriesWrite), claims: viewer, status: http.StatusForbidden},
+		{name: "owner", middleware: RejectImpersonation, claims: viewer, status: http.StatusOK},
+		{name: "impersonating", middleware: RejectImpersonation, claims: impersonated, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// send the request with the claims
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				r = r.WithContext(WithClaims(r.Context(), tt.claims))
+			}
+			tt.middleware(okHandler).ServeHTTP(w, r)
+
+			// check the status
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+
+			// the missing scopes are challenged
+			if tt.status == http.StatusForbidden && strings.Contains(tt.name, "scope") && !strings.Contains(w.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+				t.Fatalf("missing insufficient_scope challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
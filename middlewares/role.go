@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"net/http"
+	"platzi/go/rest-ws/models"
+)
+
+// RequireRole is a middleware that only lets through the authenticated users whose role includes the required one.
+// It must run after CheckAuthMiddleware, the users without the role are forbidden.
+func RequireRole(role string) func(h http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// get the claims of the authenticated user
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// check the role of the user
+			if !models.HasRole(claims.Role, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
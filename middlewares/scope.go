@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequireScopes is a middleware that only lets through the requests whose token was granted every scope.
+// It must run after CheckAuthMiddleware, the tokens without the scopes are forbidden with an
+// insufficient_scope challenge (RFC 6750).
+func RequireScopes(scopes ...string) func(h http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// get the claims of the authenticated user
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// check the scopes of the token
+			if !claims.HasScopes(scopes...) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ApiKey struct, only the hash of the key is stored.
+// The prefix is the beginning of the key, it lets the user recognize it.
+type ApiKey struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
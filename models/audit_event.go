@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent struct, a change made to a resource and who made it. The actor is nil when the change wasn't made
+// by an authenticated user, or once the actor is erased. Before and after are the snapshots of the resource.
+type AuditEvent struct {
+	Id             string          `json:"id"`
+	ActorId        *string         `json:"actor_id"`
+	ApiKeyId       *string         `json:"api_key_id"`
+	ImpersonatorId *string         `json:"impersonator_id"`
+	Action         string          `json:"action"`
+	ResourceType   string          `json:"resource_type"`
+	ResourceId     string          `json:"resource_id"`
+	Before         json.RawMessage `json:"before"`
+	After          json.RawMessage `json:"after"`
+	Method         string          `json:"method"`
+	Path           string          `json:"path"`
+	IP             string          `json:"ip"`
+	UserAgent      string          `json:"user_agent"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// AuditFilter struct, the filters of the audit events, the empty fields match every event
+type AuditFilter struct {
+	ActorId      string
+	Action       string
+	ResourceType string
+	ResourceId   string
+	From         *time.Time
+	To           *time.Time
+}
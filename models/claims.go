@@ -2,7 +2,75 @@ package models
 
 import "github.com/golang-jwt/jwt"
 
+// issuedAtLeeway is the number of seconds the issued at of a token may be ahead, the tokens issued right after
+// every token of the user was revoked are dated at the next second
+const issuedAtLeeway = 1
+
+// PurposeMFAPending is the purpose of the tokens issued after the password when the user has a second factor,
+// they are only exchanged at /login/mfa for the access tokens
+const PurposeMFAPending = "mfa_pending"
+
+// AppClaims are the claims of the access tokens.
+// The standard Id is the jti that identifies the token when it is revoked.
 type AppClaims struct {
 	UserId string `json:"user_id"`
+	Role   string `json:"role"`
+
+	// Scope is the space separated list of the scopes granted to the token
+	Scope string `json:"scope,omitempty"`
+
+	// ApiKeyId is set when the request was authenticated with an api key instead of a token
+	ApiKeyId string `json:"api_key_id,omitempty"`
+
+	// SessionId is the session of the login that issued the token, the token is rejected once it is revoked
+	SessionId string `json:"sid,omitempty"`
+
+	// Actor is the admin impersonating the user, nil unless the token was issued for an impersonation
+	Actor *Actor `json:"act,omitempty"`
+
+	// Purpose is empty for the access tokens, tokens with a purpose are rejected by the middleware
+	Purpose string `json:"purpose,omitempty"`
+
 	jwt.StandardClaims
 }
+
+// Valid is a method that checks the times of the token, it is called by the parser.
+// It accepts an issued at up to the leeway ahead.
+func (c AppClaims) Valid() error {
+	// move the issued at back by the leeway
+	standard := c.StandardClaims
+	if standard.IssuedAt > issuedAtLeeway {
+		standard.IssuedAt -= issuedAtLeeway
+	}
+
+	// check the standard claims
+	return standard.Valid()
+}
+
+// Impersonated is a method that checks if the token was issued to an admin acting as the user
+func (c *AppClaims) Impersonated() bool {
+	return c.Actor != nil && c.Actor.UserId != ""
+}
+
+// HasScopes is a method that checks if every required scope was granted
+func (c *AppClaims) HasScopes(required ...string) bool {
+	// get the granted scopes
+	granted := ParseScope(c.Scope)
+
+	// check every required scope
+	for _, scope := range required {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	// return true if every scope was granted
+	return true
+}
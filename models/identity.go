@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// UserIdentity struct, an account of an external OpenID Connect provider linked to a user.
+// The issuer and the subject identify the account at the provider.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserId    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin struct, a login started at an OpenID Connect provider that waits for its callback.
+// The state is stored hashed, the nonce and the verifier are only sent to the provider.
+type OIDCLogin struct {
+	StateHash    string    `json:"-"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"`
+	Scope        string    `json:"scope"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Actor is the act claim of a token (RFC 8693), the admin acting as the user of the token
+type Actor struct {
+	UserId string `json:"sub"`
+}
+
+// Impersonation struct, a token an admin was given to act as another user.
+// The id is the jti of the token and the admin id is nil once the admin is erased.
+type Impersonation struct {
+	Id        string    `json:"id"`
+	AdminId   *string   `json:"admin_id"`
+	UserId    string    `json:"user_id"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImpersonationRequest struct, a request made with an impersonation token
+type ImpersonationRequest struct {
+	Id              int64     `json:"id"`
+	ImpersonationId string    `json:"impersonation_id"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	IP              string    `json:"ip"`
+	CreatedAt       time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// LoginAttempts struct, the failed logins of an account or a client ip within the current window
+type LoginAttempts struct {
+	Key            string     `json:"key"`
+	Failures       int        `json:"failures"`
+	FirstFailureAt time.Time  `json:"first_failure_at"`
+	LastFailureAt  time.Time  `json:"last_failure_at"`
+	LockedUntil    *time.Time `json:"locked_until"`
+}
+
+// LoginLockout struct, the audit record of an account or a client ip that was locked out
+type LoginLockout struct {
+	Id          int64     `json:"id"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// UserMFA struct, the TOTP second factor of a user. It is only enforced once it has been confirmed.
+type UserMFA struct {
+	UserId string `json:"user_id"`
+	Secret string `json:"-"`
+
+	// LastUsedStep is the time step of the last accepted code, a code can't be used twice
+	LastUsedStep int64 `json:"-"`
+
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Enabled is a method that checks if the second factor is required at login
+func (m *UserMFA) Enabled() bool {
+	return m != nil && m.ConfirmedAt != nil
+}
+
+// RecoveryCode struct, a one time code that replaces the TOTP code when the user lost its device.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	CodeHash  string     `json:"-"`
+	UserId    string     `json:"user_id"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordReset struct, only the hash of the token is stored and it can be used once
+type PasswordReset struct {
+	TokenHash string     `json:"-"`
+	UserId    string     `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
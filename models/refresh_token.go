@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RefreshToken struct, only the hash of the token is stored.
+// Every token obtained by rotating a refresh token belongs to the family of the first one,
+// and keeps the scope granted at login.
+type RefreshToken struct {
+	Id        string     `json:"id"`
+	UserId    string     `json:"user_id"`
+	FamilyId  string     `json:"family_id"`
+	Scope     string     `json:"scope"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RevokedToken struct, the token is rejected until it expires
+type RevokedToken struct {
+	Jti       string    `json:"jti"`
+	UserId    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
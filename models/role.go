@@ -0,0 +1,26 @@
+package models
+
+// Roles of the users, every role includes the permissions of the roles below it
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleRanks is a map of each role to its rank, a higher rank includes the lower ones
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// IsValidRole is a function that checks if a role exists
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole is a function that checks if a role includes the required role
+func HasRole(role, required string) bool {
+	return IsValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
@@ -0,0 +1,67 @@
+package models
+
+import "strings"
+
+// Scopes of the tokens, they narrow down what a token may do on top of the role of its user
+const (
+	ScopeCategoriesRead   = "categories:read"
+	ScopeCategoriesWrite  = "categories:write"
+	ScopeUsersAdmin       = "users:admin"
+	ScopeUsersImpersonate = "users:impersonate"
+	ScopeAuditRead        = "audit:read"
+)
+
+// roleScopes is a map of each role to the scopes its users may be granted
+var roleScopes = map[string][]string{
+	RoleViewer: {ScopeCategoriesRead},
+	RoleEditor: {ScopeCategoriesRead, ScopeCategoriesWrite},
+	RoleAdmin:  {ScopeCategoriesRead, ScopeCategoriesWrite, ScopeUsersAdmin, ScopeUsersImpersonate, ScopeAuditRead},
+}
+
+// IsValidScope is a function that checks if a scope exists
+func IsValidScope(scope string) bool {
+	for _, scopes := range roleScopes {
+		for _, s := range scopes {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GrantScopes is a function that returns the requested scopes that the role allows.
+// When no scope is requested every scope of the role is granted.
+func GrantScopes(role string, requested []string) []string {
+	// get the scopes of the role
+	allowed := roleScopes[role]
+
+	// grant every scope of the role if none was requested
+	if len(requested) == 0 {
+		return append([]string{}, allowed...)
+	}
+
+	// keep the requested scopes that are allowed, in the order of the role
+	granted := make([]string, 0, len(requested))
+	for _, scope := range allowed {
+		for _, r := range requested {
+			if r == scope {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+
+	// return the granted scopes
+	return granted
+}
+
+// ParseScope is a function that splits a space separated scope claim into its scopes
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// FormatScope is a function that joins the scopes into a space separated scope claim
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
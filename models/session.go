@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session struct, a login of a user on a device. The id is the family of the refresh tokens of the login
+// and the sid claim of its access tokens.
+type Session struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
@@ -1,8 +1,30 @@
 package models
 
+import "time"
+
 // User struct
 type User struct {
 	Id       string `json:"id"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Role     string `json:"role"`
+
+	// EmailVerified is true once the user followed the link sent to its email
+	EmailVerified bool `json:"email_verified"`
+
+	// Profile of the user, empty until the user sets it
+	DisplayName string `json:"display_name"`
+	Locale      string `json:"locale"`
+	Timezone    string `json:"timezone"`
+
+	// DeletionScheduledAt is the time the account is purged, nil unless the user asked to delete it
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+
+	// DisabledAt is the time an admin disabled the account, a disabled user can't log in
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+// Disabled is a method that checks if an admin disabled the account
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// ExportedUser struct, the account of a user in an export, without the password hash
+type ExportedUser struct {
+	Id                  string     `json:"id"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	EmailVerified       bool       `json:"email_verified"`
+	DisplayName         string     `json:"display_name"`
+	Locale              string     `json:"locale"`
+	Timezone            string     `json:"timezone"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+}
+
+// UserExport struct, everything that is stored about a user. The hashes of the secrets are left out,
+// they identify nothing and would only help to guess the secrets.
+type UserExport struct {
+	ExportedAt         time.Time            `json:"exported_at"`
+	User               ExportedUser         `json:"user"`
+	Identities         []*UserIdentity      `json:"identities"`
+	Sessions           []*Session           `json:"sessions"`
+	RefreshTokens      []*RefreshToken      `json:"refresh_tokens"`
+	RevokedTokens      []*RevokedToken      `json:"revoked_tokens"`
+	TokensRevokedAt    *time.Time           `json:"tokens_revoked_at"`
+	ApiKeys            []*ApiKey            `json:"api_keys"`
+	PasswordResets     []*PasswordReset     `json:"password_resets"`
+	EmailVerifications []*EmailVerification `json:"email_verifications"`
+	MFA                *UserMFA             `json:"mfa"`
+	RecoveryCodes      []*RecoveryCode      `json:"recovery_codes"`
+	LoginAttempts      []*LoginAttempts     `json:"login_attempts"`
+	LoginLockouts      []*LoginLockout      `json:"login_lockouts"`
+	Impersonations     []*Impersonation     `json:"impersonations"`
+	AuditEvents        []*AuditEvent        `json:"audit_events"`
+}
+
+// NewExportedUser is a function that returns the account of a user as it is exported
+func NewExportedUser(user *User) ExportedUser {
+	return ExportedUser{
+		Id:                  user.Id,
+		Email:               user.Email,
+		Role:                user.Role,
+		EmailVerified:       user.EmailVerified,
+		DisplayName:         user.DisplayName,
+		Locale:              user.Locale,
+		Timezone:            user.Timezone,
+		DeletionScheduledAt: user.DeletionScheduledAt,
+		DisabledAt:          user.DisabledAt,
+	}
+}
@@ -0,0 +1,251 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"platzi/go/rest-ws/keys"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// clockSkew is the difference tolerated between the clocks of the provider and the server
+const clockSkew = time.Minute
+
+// keysRefreshInterval is the minimum time between two fetches of the keys, a token with an unknown
+// kid triggers a fetch since the provider may have rotated its keys
+const keysRefreshInterval = time.Minute
+
+// signingMethods are the algorithms accepted in the id tokens, the hmac ones would need the client secret as key
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ErrInvalidIDToken is returned when the id token can't be trusted
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// audience is the aud claim, a single string or a list of strings
+type audience []string
+
+// UnmarshalJSON is a method that decodes the audience in both of its forms
+func (a *audience) UnmarshalJSON(data []byte) error {
+	// decode a single audience
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	// decode a list of audiences
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// flexibleBool is a boolean claim that some providers send as a string
+type flexibleBool bool
+
+// UnmarshalJSON is a method that decodes the boolean or its string
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	// decode the string form
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = flexibleBool(s == "true")
+		return nil
+	}
+
+	// decode the boolean form
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = flexibleBool(v)
+	return nil
+}
+
+// IDTokenClaims are the claims of an id token that identify the user
+type IDTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+}
+
+// Valid is a method that checks the times of the token, it is called by the parser
+func (c *IDTokenClaims) Valid() error {
+	// check the expiration, it is required
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+
+	// check that it was not issued in the future
+	if c.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+
+	// return nil as error
+	return nil
+}
+
+// IsEmailVerified is a method that checks if the provider verified the email of the user
+func (c *IDTokenClaims) IsEmailVerified() bool {
+	return bool(c.EmailVerified)
+}
+
+// VerifyIDToken is a method that verifies the signature and the claims of an id token (OpenID Connect Core 3.1.3.7)
+// and returns its claims. The nonce is the one sent with the authorization request.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	// get the issuer
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse the token and check its signature
+	claims := &IDTokenClaims{}
+	parser := &jwt.Parser{ValidMethods: signingMethods}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		// report the errors of the provider as such
+		if errors.Is(err, ErrProvider) {
+			return nil, err
+		}
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && errors.Is(validationErr.Inner, ErrProvider) {
+			return nil, validationErr.Inner
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// check the issuer
+	if claims.Issuer != discovery.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	// check that the token was issued to this client
+	found := false
+	for _, aud := range claims.Audience {
+		if aud == p.config.ClientId {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientId {
+		return nil, fmt.Errorf("%w: authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+
+	// check the nonce, it binds the token to the login that was started
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	// check the subject, it identifies the user
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	// return the claims
+	return claims, nil
+}
+
+// key is a method that returns the key of the provider with a kid, fetching the keys when the kid is unknown.
+// Without a kid the token must be signed by the only key of the provider.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	// look up the cached keys
+	p.mutex.Lock()
+	key, ok := lookupKey(p.keys, kid)
+	stale := time.Since(p.fetchedAt) >= keysRefreshInterval
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	// the key is unknown and the keys were fetched recently
+	if !stale {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	// fetch the keys
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	// look up the fetched keys
+	p.mutex.Lock()
+	key, ok = lookupKey(p.keys, kid)
+	p.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	// return the key
+	return key, nil
+}
+
+// lookupKey is a function that finds a key by kid, or the only key when there is no kid
+func lookupKey(set map[string]interface{}, kid string) (interface{}, bool) {
+	if kid == "" {
+		if len(set) != 1 {
+			return nil, false
+		}
+		for _, key := range set {
+			return key, true
+		}
+	}
+	key, ok := set[kid]
+	return key, ok
+}
+
+// fetchKeys is a method that fetches the signing keys of the provider, the keys that can't be used are skipped
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	// get the endpoint
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	// fetch the set
+	var set keys.JWKS
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	// convert the signing keys
+	fetched := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		fetched[strings.TrimSpace(jwk.Kid)] = key
+	}
+
+	// cache the keys
+	p.mutex.Lock()
+	p.keys = fetched
+	p.fetchedAt = time.Now()
+	p.mutex.Unlock()
+
+	// return nil as error
+	return nil
+}
@@ -0,0 +1,25 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// NewCodeVerifier is a function that generates the secret of a PKCE login (RFC 7636), 43 base64url characters
+func NewCodeVerifier() (string, error) {
+	// generate the random bytes
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// encode the bytes
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge is a function that returns the S256 challenge of a verifier, it is sent with the authorization request
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
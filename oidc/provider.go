@@ -0,0 +1,246 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxResponseSize is the limit of the responses of the provider
+const maxResponseSize = 1 << 20
+
+// DefaultScopes are the scopes requested when the config has none, they give the subject and the email
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// ErrProvider is returned when the provider fails or answers something that can't be used
+var ErrProvider = errors.New("oidc provider error")
+
+// Config is the registration of the client at the provider
+type Config struct {
+	// Issuer is the URL of the provider, the discovery document is at Issuer/.well-known/openid-configuration
+	Issuer string
+
+	// ClientId and ClientSecret are the credentials of the client, the secret is empty for public clients
+	ClientId     string
+	ClientSecret string
+
+	// RedirectURL is the callback the provider redirects to with the code
+	RedirectURL string
+
+	// Scopes are the scopes requested to the provider, openid is always requested
+	Scopes []string
+
+	// HTTPClient makes the requests to the provider, it can be replaced to reach a fake provider
+	HTTPClient *http.Client
+}
+
+// Discovery is the part of the discovery document of the provider (OpenID Connect Discovery 1.0) that is used
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse is the response of the token endpoint of the provider
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider logs the users in with an OpenID Connect provider through the authorization code flow with PKCE.
+// The discovery document and the keys of the provider are fetched when they are first needed and cached.
+type Provider struct {
+	config    Config
+	client    *http.Client
+	discovery *Discovery
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	mutex     sync.Mutex
+}
+
+// NewProvider is a function that creates a new provider, it doesn't contact the provider until it is used
+func NewProvider(config Config) *Provider {
+	// use a client with a timeout if none was given
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	// request the openid scope
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	config.Scopes = []string{"openid"}
+	for _, scope := range scopes {
+		if scope != "openid" {
+			config.Scopes = append(config.Scopes, scope)
+		}
+	}
+
+	// return the provider
+	return &Provider{config: config, client: client}
+}
+
+// Issuer is a method that returns the issuer of the provider, it identifies the provider in the linked identities
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// Discover is a method that returns the discovery document of the provider, fetching it the first time
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	// return the cached document
+	p.mutex.Lock()
+	discovery := p.discovery
+	p.mutex.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	// fetch the document
+	endpoint := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	discovery = &Discovery{}
+	if err := p.getJSON(ctx, endpoint, discovery); err != nil {
+		return nil, err
+	}
+
+	// the document must belong to the configured issuer, otherwise its tokens would be accepted for it
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("%w: discovery issuer %q doesn't match %q", ErrProvider, discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrProvider)
+	}
+
+	// cache the document
+	p.mutex.Lock()
+	p.discovery = discovery
+	p.mutex.Unlock()
+
+	// return the document
+	return discovery, nil
+}
+
+// AuthCodeURL is a method that returns the URL the user is redirected to in order to log in at the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	// get the endpoint
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrProvider, err)
+	}
+
+	// add the parameters, keeping the ones of the endpoint
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientId)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+
+	// return the URL
+	return endpoint.String(), nil
+}
+
+// Exchange is a method that exchanges the code of the callback for the tokens of the provider,
+// the verifier proves that the login was started by this client
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*TokenResponse, error) {
+	// get the endpoint
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// define the form
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientId)
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// confidential clients authenticate with the basic scheme (RFC 6749 section 2.3.1)
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	// send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error exchanging code: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	// decode the response, the errors are JSON too
+	var token TokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("%w: error decoding token response with status %d: %v", ErrProvider, resp.StatusCode, err)
+	}
+
+	// check if the provider rejected the code
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("%w: code rejected with status %d: %s %s", ErrProvider, resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrProvider)
+	}
+
+	// return the tokens
+	return &token, nil
+}
+
+// getJSON is a method that fetches a JSON document of the provider
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: error fetching %s: %v", ErrProvider, endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	// check the status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: error fetching %s: status %d", ErrProvider, endpoint, resp.StatusCode)
+	}
+
+	// decode the document
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: error decoding %s: %v", ErrProvider, endpoint, err)
+	}
+
+	// return nil as error
+	return nil
+}
@@ -0,0 +1,432 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"platzi/go/rest-ws/keys"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Registration of the client at the fake provider
+const (
+	testClientId     = "rest-ws"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "http://localhost/login/oidc/callback"
+)
+
+// fakeLogin is a login started at the authorization endpoint of the fake provider
+type fakeLogin struct {
+	nonce     string
+	challenge string
+}
+
+// fakeProvider is an in-process OpenID Connect provider. It implements the discovery, the
+// authorization endpoint, the token endpoint with the PKCE check and the keys of the provider.
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex      sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	signingKid string
+	logins     map[string]fakeLogin
+	jwksHits   int
+
+	// issuer is the issuer of the discovery document, the URL of the provider when it is empty
+	issuer string
+
+	// claims are the claims of the issued id tokens, they can be changed to issue invalid tokens
+	claims func(nonce string) jwt.MapClaims
+}
+
+// newFakeProvider is a function that starts a fake provider with one signing key
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	f := &fakeProvider{t: t, keys: make(map[string]*rsa.PrivateKey), logins: make(map[string]fakeLogin)}
+	f.rotate("key-1")
+
+	// define the endpoints
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/jwks", f.jwks)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	// issue valid tokens by default
+	f.claims = func(nonce string) jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{
+			"iss":            f.server.URL,
+			"sub":            "subject-1",
+			"aud":            testClientId,
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          nonce,
+			"email":          "Jane@Example.com",
+			"email_verified": true,
+			"name":           "Jane",
+		}
+	}
+
+	return f
+}
+
+// rotate is a method that adds a new key to the provider and signs the next tokens with it
+func (f *fakeProvider) rotate(kid string) {
+	f.t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		f.t.Fatalf("error generating key: %v", err)
+	}
+
+	f.mutex.Lock()
+	f.keys[kid] = key
+	f.signingKid = kid
+	f.mutex.Unlock()
+}
+
+// newProvider is a method that returns a client of the fake provider
+func (f *fakeProvider) newProvider() *Provider {
+	return NewProvider(Config{
+		Issuer:       f.server.URL,
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   f.server.Client(),
+	})
+}
+
+// sign is a method that signs an id token with the signing key of the provider
+func (f *fakeProvider) sign(claims jwt.MapClaims) string {
+	f.t.Helper()
+
+	f.mutex.Lock()
+	kid, key := f.signingKid, f.keys[f.signingKid]
+	f.mutex.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatalf("error signing id token: %v", err)
+	}
+	return signed
+}
+
+func (f *fakeProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := f.issuer
+	if issuer == "" {
+		issuer = f.server.URL
+	}
+	json.NewEncoder(w).Encode(Discovery{
+		Issuer:                issuer,
+		AuthorizationEndpoint: f.server.URL + "/authorize",
+		TokenEndpoint:         f.server.URL + "/token",
+		JWKSURI:               f.server.URL + "/jwks",
+	})
+}
+
+// authorize logs the user in right away and redirects back with a code
+func (f *fakeProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientId || query.Get("redirect_uri") != testRedirectURL ||
+		query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	// keep the login until the code is exchanged
+	code := "code-" + query.Get("state")
+	f.mutex.Lock()
+	f.logins[code] = fakeLogin{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	f.mutex.Unlock()
+
+	// redirect to the client
+	redirect, _ := url.Parse(testRedirectURL)
+	values := url.Values{"code": {code}, "state": {query.Get("state")}}
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code for an id token, the verifier must match the challenge of the login
+func (f *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(TokenResponse{Error: code})
+	}
+
+	// authenticate the client
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientId || secret != testClientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	// get the login of the code, a code is used once
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("invalid_request")
+		return
+	}
+	f.mutex.Lock()
+	login, ok := f.logins[r.PostForm.Get("code")]
+	delete(f.logins, r.PostForm.Get("code"))
+	f.mutex.Unlock()
+	if !ok || r.PostForm.Get("redirect_uri") != testRedirectURL {
+		fail("invalid_grant")
+		return
+	}
+
+	// check the PKCE verifier
+	if CodeChallenge(r.PostForm.Get("code_verifier")) != login.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	// issue the tokens
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: "access-token",
+		TokenType:   "Bearer",
+		IDToken:     f.sign(f.claims(login.nonce)),
+	})
+}
+
+func (f *fakeProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.jwksHits++
+
+	set := keys.JWKS{}
+	for kid, key := range f.keys {
+		set.Keys = append(set.Keys, keys.JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// hits is a method that returns the number of fetches of the keys
+func (f *fakeProvider) hits() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.jwksHits
+}
+
+// login is a function that goes through the authorization code flow and returns the tokens of the provider
+func login(t *testing.T, f *fakeProvider, p *Provider, nonce string) *TokenResponse {
+	t.Helper()
+	ctx := context.Background()
+
+	// start the login
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatalf("error generating verifier: %v", err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state-1", nonce, CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("error building auth url: %v", err)
+	}
+
+	// log in at the provider without following the redirect to the client
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("error logging in at the provider: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("got status %d from the authorization endpoint, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing callback: %v", err)
+	}
+	if callback.Query().Get("state") != "state-1" {
+		t.Fatalf("got state %q, want %q", callback.Query().Get("state"), "state-1")
+	}
+
+	// exchange the code
+	token, err := p.Exchange(ctx, callback.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("error exchanging code: %v", err)
+	}
+	return token
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.newProvider()
+
+	// log in and verify the id token
+	token := login(t, f, p, "nonce-1")
+	claims, err := p.VerifyIDToken(context.Background(), token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("error verifying id token: %v", err)
+	}
+
+	// check the claims
+	if claims.Subject != "subject-1" || claims.Email != "Jane@Example.com" || !claims.IsEmailVerified() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.newProvider()
+	ctx := context.Background()
+
+	// start a login with a verifier
+	verifier, _ := NewCodeVerifier()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("error building auth url: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("error logging in at the provider: %v", err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	// exchange the code with another verifier
+	other, _ := NewCodeVerifier()
+	if _, err := p.Exchange(ctx, callback.Query().Get("code"), other); !errors.Is(err, ErrProvider) {
+		t.Fatalf("got error %v, want %v", err, ErrProvider)
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		modify func(claims jwt.MapClaims)
+	}{
+		{name: "wrong nonce", nonce: "other-nonce"},
+		{name: "wrong audience", nonce: "nonce-1", modify: func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{name: "wrong issuer", nonce: "nonce-1", modify: func(c jwt.MapClaims) { c["iss"] = "https://attacker.example.com" }},
+		{name: "expired", nonce: "nonce-1", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "missing subject", nonce: "nonce-1", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "audiences without authorized party", nonce: "nonce-1", modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientId, "other-client"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeProvider(t)
+			p := f.newProvider()
+
+			// issue the invalid token
+			valid := f.claims
+			f.claims = func(nonce string) jwt.MapClaims {
+				claims := valid(nonce)
+				if tt.modify != nil {
+					tt.modify(claims)
+				}
+				return claims
+			}
+			token := login(t, f, p, "nonce-1")
+
+			// the token is rejected
+			if _, err := p.VerifyIDToken(context.Background(), token.IDToken, tt.nonce); !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForeignSignature(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.newProvider()
+	ctx := context.Background()
+
+	// sign a token with a key that has the kid of the provider but isn't its key
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.claims("nonce-1"))
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(other)
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+
+	// the token is rejected
+	if _, err := p.VerifyIDToken(ctx, signed, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestVerifyIDTokenRefetchesKeysOnUnknownKid(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.newProvider()
+	ctx := context.Background()
+
+	// the first login fetches the keys
+	token := login(t, f, p, "nonce-1")
+	if _, err := p.VerifyIDToken(ctx, token.IDToken, "nonce-1"); err != nil {
+		t.Fatalf("error verifying id token: %v", err)
+	}
+	if got := f.hits(); got != 1 {
+		t.Fatalf("keys fetched %d times, want 1", got)
+	}
+
+	// the provider rotates its key
+	f.rotate("key-2")
+	token = login(t, f, p, "nonce-2")
+
+	// right after a fetch the unknown kid doesn't trigger another one
+	if _, err := p.VerifyIDToken(ctx, token.IDToken, "nonce-2"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidIDToken)
+	}
+	if got := f.hits(); got != 1 {
+		t.Fatalf("keys fetched %d times, want 1", got)
+	}
+
+	// once the refresh interval is over the unknown kid refetches the keys
+	p.mutex.Lock()
+	p.fetchedAt = time.Now().Add(-keysRefreshInterval)
+	p.mutex.Unlock()
+	if _, err := p.VerifyIDToken(ctx, token.IDToken, "nonce-2"); err != nil {
+		t.Fatalf("error verifying id token of the rotated key: %v", err)
+	}
+	if got := f.hits(); got != 2 {
+		t.Fatalf("keys fetched %d times, want 2", got)
+	}
+}
+
+func TestDiscoverRejectsForeignIssuer(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.newProvider()
+
+	// the discovery document claims another issuer than the configured one
+	f.issuer = "https://attacker.example.com"
+
+	// the discovery is rejected
+	if _, err := p.Discover(context.Background()); !errors.Is(err, ErrProvider) {
+		t.Fatalf("got error %v, want %v", err, ErrProvider)
+	}
+}
@@ -0,0 +1,114 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"platzi/go/rest-ws/gdpr"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Worker erases the accounts whose deletion grace period is over
+type Worker struct {
+	repo     repository.Repository
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// NewWorker is a function that creates a new worker that purges the accounts of the repository every interval
+func NewWorker(repo repository.Repository, interval time.Duration) *Worker {
+	return &Worker{
+		repo:     repo,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run is the loop of the worker, it must run in its own goroutine until Shutdown is called
+func (w *Worker) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(w.stopped)
+
+	// define a ticker to purge the accounts
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.purge()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// purge is a method that erases the accounts scheduled for deletion until now
+func (w *Worker) purge() {
+	// get the accounts
+	ids, err := w.repo.ListUsersScheduledForDeletion(context.Background(), time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// erase every account with the rows linked to it
+	for _, id := range ids {
+		err := gdpr.Erase(context.Background(), w.repo, id)
+		if errors.Is(err, gdpr.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		// record the erasure, nobody requested it when the grace period is over
+		if err := w.logPurge(id); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// logPurge is a method that appends the erasure of an account to the audit log.
+// The audit package depends on the server, so the event is inserted through the repository.
+func (w *Worker) logPurge(userId string) error {
+	// generate the id
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("error generating audit event id: %v", err)
+	}
+
+	// insert the event
+	return w.repo.InsertAuditEvent(context.Background(), &models.AuditEvent{
+		Id:           id.String(),
+		Action:       "user.purge",
+		ResourceType: "user",
+		ResourceId:   userId,
+	})
+}
+
+// Shutdown stops the loop
+func (w *Worker) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	w.once.Do(func() {
+		close(w.done)
+	})
+
+	// wait for the loop to finish
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping purge worker: %v", ctx.Err())
+	}
+}
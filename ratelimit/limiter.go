@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the period between the removals of the expired entries
+const cleanupInterval = time.Minute
+
+// Limiter allows one action per key and interval, like one email per address. The keys are kept in the memory
+// of the instance, so each instance applies the interval on its own.
+type Limiter struct {
+	interval time.Duration
+	entries  map[string]time.Time
+	mutex    sync.Mutex
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// NewLimiter is a function that creates a new limiter that allows one action per key and interval
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		entries:  make(map[string]time.Time),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Allow is a method that checks if the action of a key is allowed now, and records it if it is
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// check if the key acted within the interval
+	if last, ok := l.entries[key]; ok && now.Before(last.Add(l.interval)) {
+		return false
+	}
+
+	// record the action
+	l.entries[key] = now
+	return true
+}
+
+// Run is the cleanup loop of the limiter, it must run in its own goroutine until Shutdown is called
+func (l *Limiter) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(l.stopped)
+
+	// define a ticker to clean up the limiter
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.cleanup()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// cleanup is a method that removes the keys whose interval is over
+func (l *Limiter) cleanup() {
+	now := time.Now()
+	l.mutex.Lock()
+	for key, last := range l.entries {
+		if !now.Before(last.Add(l.interval)) {
+			delete(l.entries, key)
+		}
+	}
+	l.mutex.Unlock()
+}
+
+// Shutdown stops the cleanup loop
+func (l *Limiter) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	l.once.Do(func() {
+		close(l.done)
+	})
+
+	// wait for the loop to finish
+	select {
+	case <-l.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping rate limiter: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := NewLimiter(time.Minute)
+
+	// the first action of each key is allowed, the next ones within the interval are not
+	if !limiter.Allow("jane@example.com") {
+		t.Fatal("the first action was not allowed")
+	}
+	if limiter.Allow("jane@example.com") {
+		t.Fatal("a second action within the interval was allowed")
+	}
+	if !limiter.Allow("john@example.com") {
+		t.Fatal("the action of another key was not allowed")
+	}
+
+	// once the interval is over the key may act again and is removed by the cleanup
+	limiter.entries["jane@example.com"] = time.Now().Add(-time.Minute)
+	limiter.cleanup()
+	if _, ok := limiter.entries["jane@example.com"]; ok {
+		t.Error("the cleanup kept a key whose interval is over")
+	}
+	if _, ok := limiter.entries["john@example.com"]; !ok {
+		t.Error("the cleanup removed a key within its interval")
+	}
+	if !limiter.Allow("jane@example.com") {
+		t.Error("the action after the interval was not allowed")
+	}
+}
@@ -2,77 +2,85 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"platzi/go/rest-ws/models"
+	"time"
 )
 
+// ErrDuplicateEmail is returned by InsertUser when another user has the same email
+var ErrDuplicateEmail = errors.New("email already exists")
+
 // Repository interface is an interface that defines the methods that the repository should implement
 type Repository interface {
 	Close() error
 	InsertUser(ctx context.Context, user *models.User) error
 	GetUserById(ctx context.Context, id string) (*models.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateUserPassword(ctx context.Context, id, password string) error
+	MarkUserEmailVerified(ctx context.Context, id string) error
+	UpdateUserProfile(ctx context.Context, user *models.User) error
+	ScheduleUserDeletion(ctx context.Context, id string, at *time.Time) error
+	ListUsers(ctx context.Context, search string, page, rowsPerPage int64) ([]*models.User, int64, error)
+	SetUserDisabled(ctx context.Context, id string, at *time.Time) error
+	UpdateUserRole(ctx context.Context, id, role string) error
 	InsertCategory(ctx context.Context, category *models.Category) (int64, error)
 	GetCategoryById(ctx context.Context, id int64) (*models.Category, error)
 	GetCategoryByName(ctx context.Context, name string) (*models.Category, error)
 	UpdateCategory(ctx context.Context, category *models.Category) error
 	DeleteCategory(ctx context.Context, id int64) error
 	ListCategories(ctx context.Context, page, rowsPerPage int64) ([]*models.Category, int64, error)
-}
-
-// define a variable to store the implementation
-var implementation Repository
-
-// SetUserRepository is a setter for the implementation
-func SetRepository(repo Repository) {
-	implementation = repo
-}
-
-// Close is a function that calls the Close method of the implementation
-func Close() error {
-	return implementation.Close()
-}
-
-// InsertUser is a function that calls the InsertUser method of the implementation
-func InsertUser(ctx context.Context, user *models.User) error {
-	return implementation.InsertUser(ctx, user)
-}
-
-// GetUserById is a function that calls the GetUserById method of the implementation
-func GetUserById(ctx context.Context, id string) (*models.User, error) {
-	return implementation.GetUserById(ctx, id)
-}
-
-// GetUserByEmail is a function that calls the GetUserByEmail method of the implementation
-func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	return implementation.GetUserByEmail(ctx, email)
-}
-
-// InsertCategory is a function that calls the InsertCategory method of the implementation
-func InsertCategory(ctx context.Context, category *models.Category) (int64, error) {
-	return implementation.InsertCategory(ctx, category)
-}
-
-// GetCategoryById is a function that calls the GetCategoryById method of the implementation
-func GetCategoryById(ctx context.Context, id int64) (*models.Category, error) {
-	return implementation.GetCategoryById(ctx, id)
-}
-
-// GetCategoryByName is a function that calls the GetCategoryByName method of the implementation
-func GetCategoryByName(ctx context.Context, name string) (*models.Category, error) {
-	return implementation.GetCategoryByName(ctx, name)
-}
-
-// UpdateCategory is a function that calls the UpdateCategory method of the implementation
-func UpdateCategory(ctx context.Context, category *models.Category) error {
-	return implementation.UpdateCategory(ctx, category)
-}
-
-// DeleteCategory is a function that calls the DeleteCategory method of the implementation
-func DeleteCategory(ctx context.Context, id int64) error {
-	return implementation.DeleteCategory(ctx, id)
-}
-
-// ListCategories is a function that calls the ListCategories method of the implementation
-func ListCategories(ctx context.Context, page, rowsPerPage int64) ([]*models.Category, int64, error) {
-	return implementation.ListCategories(ctx, page, rowsPerPage)
+	InsertRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, id string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyId string) error
+	RevokeUserRefreshTokens(ctx context.Context, userId string) error
+	RevokeToken(ctx context.Context, token *models.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) error
+	RevokeUserTokens(ctx context.Context, userId string, before time.Time) error
+	GetUserTokensRevokedBefore(ctx context.Context, userId string) (time.Time, error)
+	InsertApiKey(ctx context.Context, key *models.ApiKey) error
+	GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error)
+	ListApiKeysByUser(ctx context.Context, userId string) ([]*models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, userId, id string) (bool, error)
+	TouchApiKey(ctx context.Context, id string, usedAt time.Time) error
+	InsertPasswordReset(ctx context.Context, reset *models.PasswordReset) error
+	GetPasswordResetByHash(ctx context.Context, hash string) (*models.PasswordReset, error)
+	GetLatestPasswordReset(ctx context.Context, userId string) (*models.PasswordReset, error)
+	UsePasswordReset(ctx context.Context, hash string) (bool, error)
+	InsertEmailVerification(ctx context.Context, verification *models.EmailVerification) error
+	GetEmailVerificationByHash(ctx context.Context, hash string) (*models.EmailVerification, error)
+	GetLatestEmailVerification(ctx context.Context, userId string) (*models.EmailVerification, error)
+	UseEmailVerification(ctx context.Context, hash string) (bool, error)
+	UpsertUserMFA(ctx context.Context, mfa *models.UserMFA) (bool, error)
+	GetUserMFA(ctx context.Context, userId string) (*models.UserMFA, error)
+	ConfirmUserMFA(ctx context.Context, userId string, step int64, codeHashes []string) (bool, error)
+	UseTOTPStep(ctx context.Context, userId string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userId, hash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId string, codeHashes []string) (bool, error)
+	DeleteUserMFA(ctx context.Context, userId string) (bool, error)
+	GetLoginAttempts(ctx context.Context, key string) (*models.LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, key string, now, windowStart time.Time) (*models.LoginAttempts, error)
+	LockLogin(ctx context.Context, lockout *models.LoginLockout) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error
+	ExportUserData(ctx context.Context, userId string, attemptKeys []string) (*models.UserExport, error)
+	EraseUser(ctx context.Context, userId string, attemptKeys []string) (bool, error)
+	ListUsersScheduledForDeletion(ctx context.Context, before time.Time) ([]string, error)
+	InsertSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	ListUserSessions(ctx context.Context, userId string) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, userId, id string) (bool, error)
+	RevokeUserSessions(ctx context.Context, userId string) error
+	TouchSession(ctx context.Context, id string, seenAt time.Time) error
+	DeleteStaleSessions(ctx context.Context, before time.Time) error
+	GetUserIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error)
+	InsertUserIdentity(ctx context.Context, identity *models.UserIdentity) error
+	InsertUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+	InsertOIDCLogin(ctx context.Context, login *models.OIDCLogin) error
+	ConsumeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error)
+	InsertImpersonation(ctx context.Context, impersonation *models.Impersonation) error
+	InsertImpersonationRequest(ctx context.Context, request *models.ImpersonationRequest) error
+	InsertAuditEvent(ctx context.Context, event *models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter models.AuditFilter, page, rowsPerPage int64) ([]*models.AuditEvent, int64, error)
 }
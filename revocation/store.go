@@ -0,0 +1,258 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the period between the removals of the expired entries
+const cleanupInterval = time.Minute
+
+// tokenEntry is the cached revocation state of a token
+type tokenEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+// userEntry is the cached time before which the tokens of a user are revoked
+type userEntry struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// Store checks if the tokens have been revoked. The revocations are persisted through the
+// repository and cached in memory for the ttl, so a revocation made by another instance is
+// seen after at most the ttl.
+type Store struct {
+	repo    repository.Repository
+	ttl     time.Duration
+	tokens  map[string]tokenEntry
+	users   map[string]userEntry
+	mutex   sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewStore is a function that creates a new store that persists the revocations through the repository
+// and caches them for the ttl
+func NewStore(repo repository.Repository, ttl time.Duration) *Store {
+	return &Store{
+		repo:    repo,
+		ttl:     ttl,
+		tokens:  make(map[string]tokenEntry),
+		users:   make(map[string]userEntry),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// IsRevoked is a method that checks if the token of the claims has been revoked,
+// either by itself or because every token of its user was revoked after it was issued
+func (s *Store) IsRevoked(ctx context.Context, claims *models.AppClaims) (bool, error) {
+	// check the token by itself
+	if claims.Id != "" {
+		revoked, err := s.isTokenRevoked(ctx, claims.Id)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	// check the tokens of the user
+	before, err := s.userRevokedBefore(ctx, claims.UserId)
+	if err != nil {
+		return false, err
+	}
+
+	// the token is revoked if it was issued before the time
+	return !before.IsZero() && time.Unix(claims.IssuedAt, 0).Before(before), nil
+}
+
+// isTokenRevoked is a method that checks if a jti has been revoked, using the cache when possible
+func (s *Store) isTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	// look up the cache
+	s.mutex.Lock()
+	entry, ok := s.tokens[jti]
+	s.mutex.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	// look up the repository
+	revoked, err := s.repo.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	// cache the result
+	s.mutex.Lock()
+	s.tokens[jti] = tokenEntry{revoked: revoked, expiresAt: time.Now().Add(s.ttl)}
+	s.mutex.Unlock()
+
+	// return the result
+	return revoked, nil
+}
+
+// userRevokedBefore is a method that returns the time before which the tokens of a user are revoked,
+// using the cache when possible
+func (s *Store) userRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	// look up the cache
+	s.mutex.Lock()
+	entry, ok := s.users[userId]
+	s.mutex.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.before, nil
+	}
+
+	// look up the repository
+	before, err := s.repo.GetUserTokensRevokedBefore(ctx, userId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// cache the result
+	s.mutex.Lock()
+	s.users[userId] = userEntry{before: before, expiresAt: time.Now().Add(s.ttl)}
+	s.mutex.Unlock()
+
+	// return the result
+	return before, nil
+}
+
+// Revoke is a method that revokes the token of the claims until it expires
+func (s *Store) Revoke(ctx context.Context, claims *models.AppClaims) error {
+	// tokens without jti can only be revoked with the rest of the tokens of the user
+	if claims.Id == "" {
+		return fmt.Errorf("error revoking token of user %s: the token has no jti", claims.UserId)
+	}
+
+	// persist the revocation
+	err := s.repo.RevokeToken(ctx, &models.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    claims.UserId,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// cache the revocation
+	s.mutex.Lock()
+	s.tokens[claims.Id] = tokenEntry{revoked: true, expiresAt: time.Now().Add(s.ttl)}
+	s.mutex.Unlock()
+
+	// return nil as error
+	return nil
+}
+
+// RevokeUser is a method that revokes every token, refresh token and session issued to a user until now
+func (s *Store) RevokeUser(ctx context.Context, userId string) error {
+	// define the time before which the tokens are revoked. The issued at of the tokens has second precision,
+	// so the revocation reaches the whole current second and the tokens issued later in it are dated at the
+	// next one, see IssuedAt
+	before := time.Now().Truncate(time.Second).Add(time.Second)
+
+	// persist the revocation
+	if err := s.repo.RevokeUserTokens(ctx, userId, before); err != nil {
+		return err
+	}
+
+	// revoke the refresh tokens so no new access token can be issued
+	if err := s.repo.RevokeUserRefreshTokens(ctx, userId); err != nil {
+		return err
+	}
+
+	// revoke the sessions so they are no longer listed as active
+	if err := s.repo.RevokeUserSessions(ctx, userId); err != nil {
+		return err
+	}
+
+	// cache the revocation
+	s.mutex.Lock()
+	s.users[userId] = userEntry{before: before, expiresAt: time.Now().Add(s.ttl)}
+	s.mutex.Unlock()
+
+	// return nil as error
+	return nil
+}
+
+// IssuedAt is a method that returns the issued at of a token issued now to a user. When every token of the user
+// was revoked in the current second the token is dated at the next second, so the revocation doesn't reach it.
+func (s *Store) IssuedAt(userId string) time.Time {
+	// get the revocation of the user
+	now := time.Now()
+	s.mutex.Lock()
+	entry, ok := s.users[userId]
+	s.mutex.Unlock()
+
+	// date the token after the revocation
+	if ok && now.Before(entry.before) {
+		return entry.before
+	}
+
+	// return the current time
+	return now
+}
+
+// Run is the cleanup loop of the store, it must run in its own goroutine until Shutdown is called
+func (s *Store) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(s.stopped)
+
+	// define a ticker to clean up the store
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.cleanup()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// cleanup is a method that removes the expired entries of the cache and the expired revocations of the repository
+func (s *Store) cleanup() {
+	// remove the expired entries of the cache
+	now := time.Now()
+	s.mutex.Lock()
+	for jti, entry := range s.tokens {
+		if now.After(entry.expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userId, entry := range s.users {
+		if now.After(entry.expiresAt) {
+			delete(s.users, userId)
+		}
+	}
+	s.mutex.Unlock()
+
+	// remove the revocations of the tokens that can no longer be used
+	if err := s.repo.DeleteExpiredRevokedTokens(context.Background()); err != nil {
+		log.Println(err)
+	}
+}
+
+// Shutdown stops the cleanup loop
+func (s *Store) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	// wait for the loop to finish
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping revocation store: %v", ctx.Err())
+	}
+}
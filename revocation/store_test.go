@@ -0,0 +1,107 @@
+package revocation
+
+import (
+	"context"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// revocationRepository is a repository that keeps the revocations of the users in memory
+type revocationRepository struct {
+	repository.Repository
+	before map[string]time.Time
+}
+
+func (r *revocationRepository) RevokeUserTokens(ctx context.Context, userId string, before time.Time) error {
+	r.before[userId] = before
+	return nil
+}
+
+func (r *revocationRepository) RevokeUserRefreshTokens(ctx context.Context, userId string) error {
+	return nil
+}
+
+func (r *revocationRepository) RevokeUserSessions(ctx context.Context, userId string) error {
+	return nil
+}
+
+func (r *revocationRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
+
+func (r *revocationRepository) GetUserTokensRevokedBefore(ctx context.Context, userId string) (time.Time, error) {
+	return r.before[userId], nil
+}
+
+// claimsIssuedAt is a function that returns the claims of a token of a user issued at a time
+func claimsIssuedAt(userId string, issuedAt time.Time) *models.AppClaims {
+	return &models.AppClaims{
+		UserId: userId,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: issuedAt.Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func TestRevokeUserReachesTheWholeSecond(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(&revocationRepository{before: make(map[string]time.Time)}, time.Minute)
+
+	// revoke the tokens of the user
+	now := time.Now()
+	if err := store.RevokeUser(ctx, "user"); err != nil {
+		t.Fatalf("error revoking user: %v", err)
+	}
+	reissued := store.IssuedAt("user")
+
+	tests := []struct {
+		name    string
+		claims  *models.AppClaims
+		revoked bool
+	}{
+		{name: "issued the previous second", claims: claimsIssuedAt("user", now.Add(-time.Second)), revoked: true},
+		{name: "issued in the same second", claims: claimsIssuedAt("user", now), revoked: true},
+		{name: "issued after the revocation", claims: claimsIssuedAt("user", reissued), revoked: false},
+		{name: "token of another user", claims: claimsIssuedAt("other", now), revoked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := store.IsRevoked(ctx, tt.claims)
+			if err != nil {
+				t.Fatalf("error checking revocation: %v", err)
+			}
+			if revoked != tt.revoked {
+				t.Errorf("got revoked %t, want %t", revoked, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestIssuedAtIsAcceptedByTheClaims(t *testing.T) {
+	store := NewStore(&revocationRepository{before: make(map[string]time.Time)}, time.Minute)
+
+	// a user without revocations gets the current time
+	now := time.Now()
+	if issuedAt := store.IssuedAt("user"); issuedAt.Before(now) || issuedAt.Sub(now) > time.Second {
+		t.Fatalf("got issued at %s for a user without revocations, want about %s", issuedAt, now)
+	}
+
+	// a revoked user gets the next second, which is still within the leeway of the claims
+	if err := store.RevokeUser(context.Background(), "user"); err != nil {
+		t.Fatalf("error revoking user: %v", err)
+	}
+	if err := claimsIssuedAt("user", store.IssuedAt("user")).Valid(); err != nil {
+		t.Fatalf("got %v for a token dated after the revocation, want nil", err)
+	}
+
+	// a token dated further in the future is still rejected
+	if err := claimsIssuedAt("user", time.Now().Add(5*time.Second)).Valid(); err == nil {
+		t.Fatal("got nil for a token issued in the future, want an error")
+	}
+}
@@ -5,30 +5,259 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"platzi/go/rest-ws/background"
 	"platzi/go/rest-ws/database/postgres"
+	"platzi/go/rest-ws/keys"
+	"platzi/go/rest-ws/loginguard"
+	"platzi/go/rest-ws/mail"
+	"platzi/go/rest-ws/oidc"
+	"platzi/go/rest-ws/purge"
+	"platzi/go/rest-ws/ratelimit"
 	"platzi/go/rest-ws/repository"
+	"platzi/go/rest-ws/revocation"
+	"platzi/go/rest-ws/session"
+	"platzi/go/rest-ws/validation"
+	"platzi/go/rest-ws/websocket"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
+// Defaults used when the config does not set a value
+const (
+	// DefaultShutdownTimeout is the time given to in-flight requests to finish
+	DefaultShutdownTimeout = 15 * time.Second
+
+	// DefaultShutdownHookTimeout is the time given to the background workers to stop
+	DefaultShutdownHookTimeout = 5 * time.Second
+
+	// DefaultAccessTokenTTL is the lifetime of the access tokens
+	DefaultAccessTokenTTL = 15 * time.Minute
+
+	// DefaultRefreshTokenTTL is the lifetime of the refresh tokens
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// DefaultRevocationCacheTTL is the time a token revocation check is cached
+	DefaultRevocationCacheTTL = 30 * time.Second
+
+	// DefaultPasswordResetTTL is the lifetime of the password reset tokens
+	DefaultPasswordResetTTL = time.Hour
+
+	// DefaultPasswordResetResendInterval is the time a user waits before getting another password reset email
+	DefaultPasswordResetResendInterval = time.Minute
+
+	// DefaultEmailVerificationTTL is the lifetime of the email verification tokens
+	DefaultEmailVerificationTTL = 24 * time.Hour
+
+	// DefaultEmailVerificationResendInterval is the time a user waits before asking for another verification email
+	DefaultEmailVerificationResendInterval = time.Minute
+
+	// DefaultMFAPendingTTL is the time a user has to enter the second factor after the password
+	DefaultMFAPendingTTL = 5 * time.Minute
+
+	// DefaultMFAIssuer is the name authenticator apps show next to the codes
+	DefaultMFAIssuer = "rest-ws"
+
+	// DefaultAccountDeletionGracePeriod is the time an account can be restored after the user deleted it
+	DefaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+	// DefaultAccountPurgeInterval is the period between the purges of the deleted accounts
+	DefaultAccountPurgeInterval = time.Hour
+
+	// DefaultPasswordMinLength is the minimum length of the passwords
+	DefaultPasswordMinLength = 8
+
+	// Defaults of the brute force protection of the login
+	DefaultLoginAccountThreshold = 10
+	DefaultLoginIPThreshold      = 100
+	DefaultLoginWindow           = 15 * time.Minute
+	DefaultLoginLockoutDuration  = 15 * time.Minute
+	DefaultLoginBaseDelay        = time.Second
+	DefaultLoginMaxDelay         = 30 * time.Second
+
+	// DefaultOIDCLoginTTL is the time a user has to log in at the OpenID Connect provider
+	DefaultOIDCLoginTTL = 10 * time.Minute
+
+	// DefaultImpersonationTTL is the lifetime of the tokens of an admin impersonating a user
+	DefaultImpersonationTTL = 15 * time.Minute
+
+	// DefaultReauthenticationMaxAge is the age of the login that confirms a sensitive change of an account without password
+	DefaultReauthenticationMaxAge = 5 * time.Minute
+)
+
+// Stores of the failed logins
+const (
+	// LoginAttemptStorePostgres shares the failed logins between the instances, it is the default
+	LoginAttemptStorePostgres = "postgres"
+
+	// LoginAttemptStoreMemory keeps the failed logins of each instance in memory
+	LoginAttemptStoreMemory = "memory"
+)
+
 // Config is the server config struct
 type Config struct {
 	Port        string
 	JwtSecret   string
 	DatabaseURL string
+
+	// Repository is the storage of the server, a postgres repository of the DatabaseURL when it is nil.
+	// The server closes it when it shuts down.
+	Repository repository.Repository
+
+	// JwtKeyFiles is a map of key id (kid) to the PEM file of a RSA or Ed25519 key.
+	// Public keys only verify tokens, they keep the tokens of a rotated key valid until they expire.
+	JwtKeyFiles map[string]string
+
+	// JwtSigningKey is the id of the key that signs the tokens, empty to sign with the JwtSecret
+	JwtSigningKey string
+
+	// JwtAcceptLegacySecret keeps verifying the tokens of the JwtSecret once a key of the JwtKeyFiles signs them,
+	// so the users are not logged out during the migration. Anyone who knows the secret can forge tokens,
+	// it must be turned off once the tokens of the secret have expired.
+	JwtAcceptLegacySecret bool
+
+	ShutdownTimeout time.Duration
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// ShutdownHookTimeout bounds the shutdown hooks, apart from the ShutdownTimeout so the workers
+	// are still waited for when the in-flight requests used up the whole ShutdownTimeout
+	ShutdownHookTimeout time.Duration
+
+	// RevocationCacheTTL is the time a revocation made by another instance may take to be seen
+	RevocationCacheTTL time.Duration
+
+	// PasswordResetTTL is the lifetime of the password reset tokens
+	PasswordResetTTL time.Duration
+
+	// PasswordResetResendInterval is the minimum time between two password reset emails to the same user
+	PasswordResetResendInterval time.Duration
+
+	// RequireEmailVerification blocks the login of the users that haven't verified their email
+	RequireEmailVerification bool
+
+	// EmailVerificationTTL is the lifetime of the email verification tokens
+	EmailVerificationTTL time.Duration
+
+	// EmailVerificationResendInterval is the minimum time between two verification emails to the same address
+	EmailVerificationResendInterval time.Duration
+
+	// MFAPendingTTL is the lifetime of the tokens that are exchanged for the access tokens with the second factor
+	MFAPendingTTL time.Duration
+
+	// MFAIssuer is the issuer of the otpauth URIs
+	MFAIssuer string
+
+	// AccountDeletionGracePeriod is the time a deleted account is kept, logging in during it restores the account
+	AccountDeletionGracePeriod time.Duration
+
+	// AccountPurgeInterval is the period between the purges of the deleted accounts
+	AccountPurgeInterval time.Duration
+
+	// PasswordMinLength and the PasswordRequire fields are the rules of the new passwords
+	PasswordMinLength     int
+	PasswordRequireUpper  bool
+	PasswordRequireLower  bool
+	PasswordRequireDigit  bool
+	PasswordRequireSymbol bool
+
+	// PasswordBlocklistFiles are lists of breached passwords that are rejected, one per line,
+	// along with the common passwords that are always rejected
+	PasswordBlocklistFiles []string
+
+	// LoginAttemptStore is where the failed logins are counted, postgres or memory
+	LoginAttemptStore string
+
+	// LoginAccountThreshold and LoginIPThreshold are the failed logins of an account or a client ip
+	// within the LoginWindow that lock it out for the LoginLockoutDuration
+	LoginAccountThreshold int
+	LoginIPThreshold      int
+	LoginWindow           time.Duration
+	LoginLockoutDuration  time.Duration
+
+	// LoginBaseDelay is the wait after the second failed login, it doubles with every failure up to LoginMaxDelay
+	LoginBaseDelay time.Duration
+	LoginMaxDelay  time.Duration
+
+	// AppURL is the URL of the frontend, the links sent by email point to it
+	AppURL string
+
+	// SMTPAddr is the host:port of the SMTP server, when it is empty the emails are kept
+	// in an outbox and written to MailOutboxDir if it is set
+	SMTPAddr      string
+	SMTPUsername  string
+	SMTPPassword  string
+	MailFrom      string
+	MailOutboxDir string
+
+	// OIDCIssuer is the URL of the OpenID Connect provider, the login with the provider is disabled when it is empty.
+	// OIDCClientId, OIDCClientSecret and OIDCRedirectURL are the registration of the server at the provider.
+	OIDCIssuer       string
+	OIDCClientId     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+
+	// OIDCScopes are the scopes requested to the provider, openid, email and profile when it is empty
+	OIDCScopes []string
+
+	// OIDCLoginTTL is the time a user has to come back from the provider
+	OIDCLoginTTL time.Duration
+
+	// OIDCHTTPClient makes the requests to the provider, a client with a timeout when it is nil
+	OIDCHTTPClient *http.Client
+
+	// ImpersonationTTL is the lifetime of the tokens of an admin impersonating a user, they can't be refreshed
+	ImpersonationTTL time.Duration
+
+	// ReauthenticationMaxAge is how recent the login of an account without password must be to confirm
+	// a sensitive change, like deleting the account. Those accounts log in with the OpenID Connect provider.
+	ReauthenticationMaxAge time.Duration
 }
 
 // Server is the interface that all servers must implement
 type Server interface {
 	Config() *Config
+	Repository() repository.Repository
+	Hub() *websocket.Hub
+	Revocations() *revocation.Store
+	Keys() *keys.KeySet
+	Mailer() mail.Mailer
+	LoginGuard() *loginguard.Guard
+	PasswordPolicy() *validation.PasswordPolicy
+	Sessions() *session.Tracker
+	OIDC() *oidc.Provider
+	Background() *background.Runner
+	VerificationLimiter() *ratelimit.Limiter
 }
 
+// ShutdownHook is a function that releases a resource when the server shuts down
+type ShutdownHook func(ctx context.Context) error
+
 // Broker is the server struct that implements the Server interface
 type Broker struct {
-	config *Config
-	router *mux.Router
+	config      *Config
+	router      *mux.Router
+	hub         *websocket.Hub
+	revocations *revocation.Store
+	keys        *keys.KeySet
+	mailer      mail.Mailer
+	loginGuard  *loginguard.Guard
+	passwords   *validation.PasswordPolicy
+	purger      *purge.Worker
+	sessions    *session.Tracker
+	oidc        *oidc.Provider
+	background  *background.Runner
+	verifyLimit *ratelimit.Limiter
+	httpServer  *http.Server
+	listener    net.Listener
+	repository  repository.Repository
+	hooks       []ShutdownHook
+	closed      bool
+	mutex       sync.Mutex
 }
 
 // Config returns the server config
@@ -36,6 +265,61 @@ func (b *Broker) Config() *Config {
 	return b.config
 }
 
+// Repository returns the storage of the server
+func (b *Broker) Repository() repository.Repository {
+	return b.repository
+}
+
+// Hub returns the websocket hub
+func (b *Broker) Hub() *websocket.Hub {
+	return b.hub
+}
+
+// Keys returns the keys that sign and verify the tokens
+func (b *Broker) Keys() *keys.KeySet {
+	return b.keys
+}
+
+// Mailer returns the mailer that sends the emails
+func (b *Broker) Mailer() mail.Mailer {
+	return b.mailer
+}
+
+// LoginGuard returns the guard that tracks the failed logins
+func (b *Broker) LoginGuard() *loginguard.Guard {
+	return b.loginGuard
+}
+
+// PasswordPolicy returns the rules of the new passwords
+func (b *Broker) PasswordPolicy() *validation.PasswordPolicy {
+	return b.passwords
+}
+
+// Sessions returns the tracker of the sessions of the users
+func (b *Broker) Sessions() *session.Tracker {
+	return b.sessions
+}
+
+// OIDC returns the OpenID Connect provider, nil when the login with a provider is disabled
+func (b *Broker) OIDC() *oidc.Provider {
+	return b.oidc
+}
+
+// Background returns the runner of the tasks that outlive their request
+func (b *Broker) Background() *background.Runner {
+	return b.background
+}
+
+// VerificationLimiter returns the limiter of the verification emails asked for each address
+func (b *Broker) VerificationLimiter() *ratelimit.Limiter {
+	return b.verifyLimit
+}
+
+// Revocations returns the store of the revoked tokens
+func (b *Broker) Revocations() *revocation.Store {
+	return b.revocations
+}
+
 // NewServer creates a new server instance
 func NewServer(ctx context.Context, config *Config) (*Broker, error) {
 	// Validate config port is not empty
@@ -43,55 +327,372 @@ func NewServer(ctx context.Context, config *Config) (*Broker, error) {
 		return nil, errors.New("port is required")
 	}
 
-	// Validate config JWTSecret is not empty
-	if config.JwtSecret == "" {
-		return nil, errors.New("jwt secret is required")
+	// Validate config has a JWTSecret or JWT keys
+	if config.JwtSecret == "" && len(config.JwtKeyFiles) == 0 {
+		return nil, errors.New("jwt secret or jwt keys are required")
 	}
 
-	// Validate config DatabaseURL is not empty
-	if config.DatabaseURL == "" {
+	// Validate config DatabaseURL is not empty, unless a repository is given
+	if config.DatabaseURL == "" && config.Repository == nil {
 		return nil, errors.New("database url is required")
 	}
 
+	// Use the default shutdown timeout if none was configured
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if config.ShutdownHookTimeout <= 0 {
+		config.ShutdownHookTimeout = DefaultShutdownHookTimeout
+	}
+
+	// Use the default token lifetimes if none were configured
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if config.RefreshTokenTTL <= 0 {
+		config.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+
+	// Use the default revocation cache ttl if none was configured
+	if config.RevocationCacheTTL <= 0 {
+		config.RevocationCacheTTL = DefaultRevocationCacheTTL
+	}
+
+	// Use the default password reset ttl if none was configured
+	if config.PasswordResetTTL <= 0 {
+		config.PasswordResetTTL = DefaultPasswordResetTTL
+	}
+	if config.PasswordResetResendInterval <= 0 {
+		config.PasswordResetResendInterval = DefaultPasswordResetResendInterval
+	}
+
+	// Use the default email verification durations if none were configured
+	if config.EmailVerificationTTL <= 0 {
+		config.EmailVerificationTTL = DefaultEmailVerificationTTL
+	}
+	if config.EmailVerificationResendInterval <= 0 {
+		config.EmailVerificationResendInterval = DefaultEmailVerificationResendInterval
+	}
+
+	// Use the default mfa settings if none were configured
+	if config.MFAPendingTTL <= 0 {
+		config.MFAPendingTTL = DefaultMFAPendingTTL
+	}
+	if config.MFAIssuer == "" {
+		config.MFAIssuer = DefaultMFAIssuer
+	}
+
+	// Use the default account deletion settings if none were configured
+	if config.AccountDeletionGracePeriod <= 0 {
+		config.AccountDeletionGracePeriod = DefaultAccountDeletionGracePeriod
+	}
+	if config.AccountPurgeInterval <= 0 {
+		config.AccountPurgeInterval = DefaultAccountPurgeInterval
+	}
+
+	// Create the password policy
+	if config.PasswordMinLength <= 0 {
+		config.PasswordMinLength = DefaultPasswordMinLength
+	}
+	passwords, err := validation.NewPasswordPolicy(config.PasswordMinLength, config.PasswordBlocklistFiles...)
+	if err != nil {
+		return nil, err
+	}
+	passwords.RequireUpper = config.PasswordRequireUpper
+	passwords.RequireLower = config.PasswordRequireLower
+	passwords.RequireDigit = config.PasswordRequireDigit
+	passwords.RequireSymbol = config.PasswordRequireSymbol
+
+	// Use the default brute force protection if none was configured
+	if config.LoginAttemptStore == "" {
+		config.LoginAttemptStore = LoginAttemptStorePostgres
+	}
+	if config.LoginAccountThreshold <= 0 {
+		config.LoginAccountThreshold = DefaultLoginAccountThreshold
+	}
+	if config.LoginIPThreshold <= 0 {
+		config.LoginIPThreshold = DefaultLoginIPThreshold
+	}
+	if config.LoginWindow <= 0 {
+		config.LoginWindow = DefaultLoginWindow
+	}
+	if config.LoginLockoutDuration <= 0 {
+		config.LoginLockoutDuration = DefaultLoginLockoutDuration
+	}
+	if config.LoginBaseDelay <= 0 {
+		config.LoginBaseDelay = DefaultLoginBaseDelay
+	}
+	if config.LoginMaxDelay <= 0 {
+		config.LoginMaxDelay = DefaultLoginMaxDelay
+	}
+
+	// Validate the OpenID Connect provider is fully configured
+	if config.OIDCIssuer != "" && (config.OIDCClientId == "" || config.OIDCRedirectURL == "") {
+		return nil, errors.New("oidc client id and redirect url are required with an oidc issuer")
+	}
+	if config.OIDCLoginTTL <= 0 {
+		config.OIDCLoginTTL = DefaultOIDCLoginTTL
+	}
+
+	// Use the default impersonation ttl if none was configured
+	if config.ImpersonationTTL <= 0 {
+		config.ImpersonationTTL = DefaultImpersonationTTL
+	}
+
+	// Use the default reauthentication max age if none was configured
+	if config.ReauthenticationMaxAge <= 0 {
+		config.ReauthenticationMaxAge = DefaultReauthenticationMaxAge
+	}
+
+	// Load the keys of the tokens
+	keySet, err := newKeySet(config)
+	if err != nil {
+		return nil, fmt.Errorf("error loading jwt keys: %v", err)
+	}
+
+	// Init the repository before the routes are bound, the handlers reach it through the server
+	repo := config.Repository
+	if repo == nil {
+		repo, err = postgres.NewPostgresRepository(config.DatabaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing repository: %v", err)
+		}
+	}
+
+	// Create the guard of the login
+	loginGuard, err := newLoginGuard(config, repo)
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+
 	// Create new broker
 	broker := &Broker{
-		config: config,
-		router: mux.NewRouter(),
+		config:      config,
+		router:      mux.NewRouter(),
+		repository:  repo,
+		hub:         websocket.NewHub(),
+		revocations: revocation.NewStore(repo, config.RevocationCacheTTL),
+		keys:        keySet,
+		mailer:      newMailer(config),
+		loginGuard:  loginGuard,
+		passwords:   passwords,
+		purger:      purge.NewWorker(repo, config.AccountPurgeInterval),
+		sessions:    session.NewTracker(repo, config.RevocationCacheTTL, config.RefreshTokenTTL),
+		background:  background.NewRunner(),
+		verifyLimit: ratelimit.NewLimiter(config.EmailVerificationResendInterval),
+	}
+
+	// Create the OpenID Connect provider if it is configured
+	if config.OIDCIssuer != "" {
+		broker.oidc = oidc.NewProvider(oidc.Config{
+			Issuer:       config.OIDCIssuer,
+			ClientId:     config.OIDCClientId,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+			Scopes:       config.OIDCScopes,
+			HTTPClient:   config.OIDCHTTPClient,
+		})
 	}
 
 	// Return broker and a nil error
 	return broker, nil
 }
 
-// Start starts the server
-func (b *Broker) Start(binder func(s Server, r *mux.Router)) error {
+// newMailer is a function that creates the mailer of the config, an outbox when there is no SMTP server
+func newMailer(config *Config) mail.Mailer {
+	// use the SMTP server if it is configured
+	if config.SMTPAddr != "" {
+		return mail.NewSMTPMailer(config.SMTPAddr, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+	}
+
+	// keep the emails in the outbox
+	log.Println("No SMTP server configured, emails are kept in the outbox", config.MailOutboxDir)
+	return mail.NewOutbox(config.MailOutboxDir)
+}
+
+// newLoginGuard is a function that creates the guard of the login with the store of the config
+func newLoginGuard(config *Config, repo repository.Repository) (*loginguard.Guard, error) {
+	// define the store
+	var store loginguard.Store
+	switch config.LoginAttemptStore {
+	case LoginAttemptStorePostgres:
+		store = loginguard.NewRepositoryStore(repo)
+	case LoginAttemptStoreMemory:
+		store = loginguard.NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("unknown login attempt store %q", config.LoginAttemptStore)
+	}
+
+	// create the guard
+	return loginguard.NewGuard(store, loginguard.Config{
+		AccountThreshold: config.LoginAccountThreshold,
+		IPThreshold:      config.LoginIPThreshold,
+		Window:           config.LoginWindow,
+		LockoutDuration:  config.LoginLockoutDuration,
+		BaseDelay:        config.LoginBaseDelay,
+		MaxDelay:         config.LoginMaxDelay,
+	}), nil
+}
+
+// newKeySet is a function that creates the key set of the config.
+// The JwtSecret is a key without id, it signs the tokens when there is no signing key. Once a signing key is set
+// it only verifies the tokens signed before, and only while JwtAcceptLegacySecret is on.
+func newKeySet(config *Config) (*keys.KeySet, error) {
+	// define the keys
+	var list []*keys.Key
+
+	// add the legacy secret
+	switch {
+	case config.JwtSecret == "":
+		// there is no legacy secret
+	case config.JwtSigningKey == "":
+		// the secret signs the tokens
+		list = append(list, keys.NewHMACKey("", config.JwtSecret))
+	case config.JwtAcceptLegacySecret:
+		// the secret verifies the tokens signed before the migration to the keys
+		log.Println("Warning: the tokens of the legacy jwt secret are still accepted, turn JWT_ACCEPT_LEGACY_SECRET off once they have expired")
+		list = append(list, keys.NewHMACKey("", config.JwtSecret))
+	default:
+		log.Println("The legacy jwt secret is ignored, the tokens are signed and verified with the jwt keys")
+	}
+
+	// load the key files
+	for id, path := range config.JwtKeyFiles {
+		if id == "" {
+			return nil, fmt.Errorf("the key %s has no id", path)
+		}
+		key, err := keys.LoadKeyFile(id, path)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+
+	// create the set
+	return keys.NewKeySet(config.JwtSigningKey, list...)
+}
+
+// OnShutdown registers a hook that runs after the http listener has been closed and before the repository is closed.
+// Hooks are meant for background workers and run in the order they were registered.
+func (b *Broker) OnShutdown(hook ShutdownHook) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.hooks = append(b.hooks, hook)
+}
+
+// Addr returns the address the server is listening on, or nil if it has not started yet
+func (b *Broker) Addr() net.Addr {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.listener == nil {
+		return nil
+	}
+
+	return b.listener.Addr()
+}
+
+// Start starts the server and blocks until it stops serving.
+// It returns nil when the server was stopped through Shutdown.
+func (b *Broker) Start(binder func(s Server, r *mux.Router) error) error {
 	// Inits the broker router
 	b.router = mux.NewRouter()
 
 	// Bind the router
-	binder(b, b.router)
+	if err := binder(b, b.router); err != nil {
+		return fmt.Errorf("error binding routes: %v", err)
+	}
 
 	// implement cors
 	handler := cors.AllowAll().Handler(b.router)
 
-	// init repository
-	repo, err := postgres.NewPostgresRepository(b.config.DatabaseURL)
+	// Open the listener, the port may be ":0" to let the system pick a free one
+	listener, err := net.Listen("tcp", b.config.Port)
 	if err != nil {
-		return fmt.Errorf("error initializing repository: %v", err)
+		return fmt.Errorf("error listening on %s: %v", b.config.Port, err)
+	}
+
+	// Keep track of the resources that must be released on shutdown
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		listener.Close()
+		return nil
 	}
+	b.listener = listener
+	b.httpServer = &http.Server{Handler: handler}
+	httpServer := b.httpServer
 
-	// init abstract repository
-	repository.SetRepository(repo)
+	// Start the background workers, they are stopped before the repository is closed
+	go b.hub.Run()
+	go b.revocations.Run()
+	go b.loginGuard.Run()
+	go b.purger.Run()
+	go b.sessions.Run()
+	go b.verifyLimit.Run()
+	b.hooks = append(b.hooks, b.background.Shutdown, b.hub.Shutdown, b.revocations.Shutdown, b.loginGuard.Shutdown, b.purger.Shutdown, b.sessions.Shutdown, b.verifyLimit.Shutdown)
+	b.mutex.Unlock()
 
 	// Loging server start
-	log.Println("Server started on port", b.config.Port)
+	log.Println("Server started on", listener.Addr())
 
 	// Start the server
-	if err := http.ListenAndServe(b.config.Port, handler); err != nil {
-		log.Fatal("ListenAndServe", err)
-		return err
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving http: %v", err)
 	}
 
 	// Return nil error
 	return nil
 }
+
+// Shutdown gracefully stops the server. It stops accepting new connections and waits for
+// in-flight requests to finish, then runs the shutdown hooks and finally closes the repository,
+// so the workers finish their current run with the repository still open.
+// Draining is bounded by the config ShutdownTimeout and by the given context, the hooks get their
+// own ShutdownHookTimeout so the workers are waited for even when the draining timed out.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	// Bound the whole teardown by the configured timeout
+	ctx, cancel := context.WithTimeout(ctx, b.config.ShutdownTimeout)
+	defer cancel()
+
+	// Take the resources so a second call does not release them twice
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	httpServer, repo, hooks := b.httpServer, b.repository, b.hooks
+	b.mutex.Unlock()
+
+	// define a slice to collect the errors of every step
+	var errs []error
+
+	// Stop the http listener and wait for the in-flight requests
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error shutting down http server: %v", err))
+		}
+	}
+
+	// Stop the background workers with their own deadline, the one of the draining may be over
+	hookCtx, hookCancel := context.WithTimeout(context.Background(), b.config.ShutdownHookTimeout)
+	defer hookCancel()
+	for _, hook := range hooks {
+		if err := hook(hookCtx); err != nil {
+			errs = append(errs, fmt.Errorf("error running shutdown hook: %v", err))
+		}
+	}
+
+	// Close the repository once no request nor worker can use it anymore
+	if repo != nil {
+		if err := repo.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing repository: %v", err))
+		}
+	}
+
+	// Return the collected errors, nil if there are none
+	return errors.Join(errs...)
+}
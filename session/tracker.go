@@ -0,0 +1,221 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the period between the removals of the expired entries
+const cleanupInterval = time.Minute
+
+// TouchInterval is the minimum period between two updates of the last seen time of a session
+const TouchInterval = time.Minute
+
+// maxUserAgentLength is the length the user agents are truncated to
+const maxUserAgentLength = 512
+
+// entry is the cached state of a session
+type entry struct {
+	active    bool
+	touchedAt time.Time
+	expiresAt time.Time
+}
+
+// Tracker records the logins of the users as sessions and checks that the session of a token has
+// not been revoked. The state of the sessions is cached in memory for the ttl, so a revocation made
+// by another instance is seen after at most the ttl.
+type Tracker struct {
+	repo    repository.Repository
+	ttl     time.Duration
+	idleTTL time.Duration
+	entries map[string]entry
+	mutex   sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewTracker is a function that creates a new tracker that persists the sessions through the repository,
+// caches them for the ttl and removes the sessions that have not been seen for the idle ttl
+func NewTracker(repo repository.Repository, ttl, idleTTL time.Duration) *Tracker {
+	return &Tracker{
+		repo:    repo,
+		ttl:     ttl,
+		idleTTL: idleTTL,
+		entries: make(map[string]entry),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start is a method that records a new session of a user
+func (t *Tracker) Start(ctx context.Context, id, userId, userAgent, ip string) (*models.Session, error) {
+	// truncate the user agent, it is sent by the client
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	// define the session
+	now := time.Now()
+	session := &models.Session{
+		Id:         id,
+		UserId:     userId,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	// persist the session
+	if err := t.repo.InsertSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	// cache the session
+	t.mutex.Lock()
+	t.entries[id] = entry{active: true, touchedAt: now, expiresAt: time.Now().Add(t.ttl)}
+	t.mutex.Unlock()
+
+	// return the session
+	return session, nil
+}
+
+// IsActive is a method that checks if the session of the claims is still active and records that it was seen.
+// The tokens issued without a session are not tied to one, so they are always active.
+func (t *Tracker) IsActive(ctx context.Context, claims *models.AppClaims) (bool, error) {
+	// check if the token is tied to a session
+	if claims.SessionId == "" {
+		return true, nil
+	}
+
+	// look up the cache
+	now := time.Now()
+	t.mutex.Lock()
+	cached, ok := t.entries[claims.SessionId]
+	t.mutex.Unlock()
+
+	// look up the repository if the session is not cached
+	if !ok || now.After(cached.expiresAt) {
+		session, err := t.repo.GetSession(ctx, claims.SessionId)
+		if err != nil {
+			return false, err
+		}
+
+		// a missing session was revoked and removed, or belongs to another user
+		active := session != nil && session.RevokedAt == nil && session.UserId == claims.UserId
+
+		// keep the time it was last touched by this instance
+		cached = entry{active: active, touchedAt: cached.touchedAt, expiresAt: now.Add(t.ttl)}
+		if session != nil && session.LastSeenAt.After(cached.touchedAt) {
+			cached.touchedAt = session.LastSeenAt
+		}
+	}
+
+	// check if the session is active
+	if !cached.active {
+		t.store(claims.SessionId, cached)
+		return false, nil
+	}
+
+	// record that the session was seen, at most once per interval
+	if now.Sub(cached.touchedAt) >= TouchInterval {
+		if err := t.repo.TouchSession(ctx, claims.SessionId, now); err != nil {
+			// the session is still usable, the last seen time is only informative
+			log.Println(err)
+		} else {
+			cached.touchedAt = now
+		}
+	}
+
+	// cache the session
+	t.store(claims.SessionId, cached)
+
+	// return the session is active
+	return true, nil
+}
+
+// store is a method that caches the state of a session
+func (t *Tracker) store(id string, cached entry) {
+	t.mutex.Lock()
+	t.entries[id] = cached
+	t.mutex.Unlock()
+}
+
+// Revoke is a method that revokes a session of a user and the refresh tokens issued for it.
+// It returns false if the user has no such active session.
+func (t *Tracker) Revoke(ctx context.Context, userId, id string) (bool, error) {
+	// persist the revocation
+	revoked, err := t.repo.RevokeSession(ctx, userId, id)
+	if err != nil || !revoked {
+		return revoked, err
+	}
+
+	// revoke the refresh tokens so no new access token can be issued for the session
+	if err := t.repo.RevokeRefreshTokenFamily(ctx, id); err != nil {
+		return false, err
+	}
+
+	// cache the revocation
+	t.store(id, entry{active: false, expiresAt: time.Now().Add(t.ttl)})
+
+	// return the session was revoked
+	return true, nil
+}
+
+// Run is the cleanup loop of the tracker, it must run in its own goroutine until Shutdown is called
+func (t *Tracker) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(t.stopped)
+
+	// define a ticker to clean up the tracker
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.cleanup()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// cleanup is a method that removes the expired entries of the cache and the idle sessions of the repository
+func (t *Tracker) cleanup() {
+	// remove the expired entries of the cache
+	now := time.Now()
+	t.mutex.Lock()
+	for id, cached := range t.entries {
+		if now.After(cached.expiresAt) {
+			delete(t.entries, id)
+		}
+	}
+	t.mutex.Unlock()
+
+	// remove the sessions whose refresh tokens have expired
+	if err := t.repo.DeleteStaleSessions(context.Background(), now.Add(-t.idleTTL)); err != nil {
+		log.Println(err)
+	}
+}
+
+// Shutdown stops the cleanup loop
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	t.once.Do(func() {
+		close(t.done)
+	})
+
+	// wait for the loop to finish
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping session tracker: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"platzi/go/rest-ws/models"
+	"platzi/go/rest-ws/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionRepository is a repository that keeps the sessions in memory and counts their reads
+type sessionRepository struct {
+	repository.Repository
+	sessions map[string]*models.Session
+	reads    int
+	touches  int
+}
+
+func newSessionRepository() *sessionRepository {
+	return &sessionRepository{sessions: make(map[string]*models.Session)}
+}
+
+func (r *sessionRepository) InsertSession(ctx context.Context, session *models.Session) error {
+	copied := *session
+	r.sessions[session.Id] = &copied
+	return nil
+}
+
+func (r *sessionRepository) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	r.reads++
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (r *sessionRepository) TouchSession(ctx context.Context, id string, seenAt time.Time) error {
+	r.touches++
+	if session, ok := r.sessions[id]; ok {
+		session.LastSeenAt = seenAt
+	}
+	return nil
+}
+
+func (r *sessionRepository) RevokeSession(ctx context.Context, userId, id string) (bool, error) {
+	session, ok := r.sessions[id]
+	if !ok || session.UserId != userId || session.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return true, nil
+}
+
+func (r *sessionRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	return nil
+}
+
+func TestIsActive(t *testing.T) {
+	ctx := context.Background()
+	revokedAt := time.Now().Add(-time.Minute)
+	repo := newSessionRepository()
+	repo.sessions["active"] = &models.Session{Id: "active", UserId: "user", LastSeenAt: time.Now()}
+	repo.sessions["revoked"] = &models.Session{Id: "revoked", UserId: "user", LastSeenAt: time.Now(), RevokedAt: &revokedAt}
+
+	tests := []struct {
+		name   string
+		claims *models.AppClaims
+		active bool
+	}{
+		{name: "token without session", claims: &models.AppClaims{UserId: "user"}, active: true},
+		{name: "active session", claims: &models.AppClaims{UserId: "user", SessionId: "active"}, active: true},
+		{name: "revoked session", claims: &models.AppClaims{UserId: "user", SessionId: "revoked"}, active: false},
+		{name: "removed session", claims: &models.AppClaims{UserId: "user", SessionId: "removed"}, active: false},
+		{name: "session of another user", claims: &models.AppClaims{UserId: "other", SessionId: "active"}, active: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// use a new tracker so nothing is cached
+			tracker := NewTracker(repo, time.Minute, time.Hour)
+			active, err := tracker.IsActive(ctx, tt.claims)
+			if err != nil {
+				t.Fatalf("error checking session: %v", err)
+			}
+			if active != tt.active {
+				t.Errorf("got active %t, want %t", active, tt.active)
+			}
+		})
+	}
+}
+
+func TestIsActiveCachesTheSession(t *testing.T) {
+	ctx := context.Background()
+	repo := newSessionRepository()
+	tracker := NewTracker(repo, time.Minute, time.Hour)
+
+	// start a session, it is cached and was just seen
+	if _, err := tracker.Start(ctx, "session", "user", strings.Repeat("a", 2*maxUserAgentLength), "203.0.113.7"); err != nil {
+		t.Fatalf("error starting session: %v", err)
+	}
+	if got := len(repo.sessions["session"].UserAgent); got != maxUserAgentLength {
+		t.Errorf("got user agent of %d bytes, want %d", got, maxUserAgentLength)
+	}
+
+	// the checks within the ttl don't read nor touch the repository
+	claims := &models.AppClaims{UserId: "user", SessionId: "session"}
+	for i := 0; i < 3; i++ {
+		if active, err := tracker.IsActive(ctx, claims); err != nil || !active {
+			t.Fatalf("got active %t and error %v, want true and nil", active, err)
+		}
+	}
+	if repo.reads != 0 || repo.touches != 0 {
+		t.Errorf("got %d reads and %d touches, want none", repo.reads, repo.touches)
+	}
+
+	// a revocation through the tracker is seen right away
+	revoked, err := tracker.Revoke(ctx, "user", "session")
+	if err != nil || !revoked {
+		t.Fatalf("got revoked %t and error %v, want true and nil", revoked, err)
+	}
+	if active, err := tracker.IsActive(ctx, claims); err != nil || active {
+		t.Fatalf("got active %t and error %v after the revocation, want false and nil", active, err)
+	}
+
+	// a session can't be revoked twice nor by another user
+	if revoked, _ := tracker.Revoke(ctx, "user", "session"); revoked {
+		t.Error("revoked a session twice")
+	}
+	if revoked, _ := tracker.Revoke(ctx, "other", "session"); revoked {
+		t.Error("revoked the session of another user")
+	}
+}
+
+func TestIsActiveTouchesTheIdleSessions(t *testing.T) {
+	ctx := context.Background()
+	repo := newSessionRepository()
+	seenAt := time.Now().Add(-2 * TouchInterval)
+	repo.sessions["session"] = &models.Session{Id: "session", UserId: "user", LastSeenAt: seenAt}
+	tracker := NewTracker(repo, time.Minute, time.Hour)
+
+	// the session wasn't seen within the interval, so the check records it
+	claims := &models.AppClaims{UserId: "user", SessionId: "session"}
+	if active, err := tracker.IsActive(ctx, claims); err != nil || !active {
+		t.Fatalf("got active %t and error %v, want true and nil", active, err)
+	}
+	if repo.touches != 1 || !repo.sessions["session"].LastSeenAt.After(seenAt) {
+		t.Fatalf("got %d touches and last seen at %s, want 1 after %s", repo.touches, repo.sessions["session"].LastSeenAt, seenAt)
+	}
+
+	// the next check is within the interval
+	if _, err := tracker.IsActive(ctx, claims); err != nil {
+		t.Fatalf("error checking session: %v", err)
+	}
+	if repo.touches != 1 {
+		t.Errorf("got %d touches, want 1", repo.touches)
+	}
+}
@@ -0,0 +1,126 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of the codes, they are the defaults of RFC 6238 that every authenticator app supports
+const (
+	// Period is the time a code is valid
+	Period = 30 * time.Second
+
+	// Digits is the length of the codes
+	Digits = 6
+
+	// secretSize is the number of random bytes of a secret, 160 bits as RFC 4226 recommends
+	secretSize = 20
+)
+
+// ErrInvalidSecret is returned when a secret is not valid base32
+var ErrInvalidSecret = errors.New("invalid totp secret")
+
+// encoding is the base32 encoding of the secrets, authenticator apps expect it without padding
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret is a function that generates a random secret encoded in base32
+func GenerateSecret() (string, error) {
+	// read the random bytes
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating totp secret: %v", err)
+	}
+
+	// encode the secret
+	return encoding.EncodeToString(b), nil
+}
+
+// URI is a function that returns the otpauth URI of a secret, authenticator apps read it from a QR code
+func URI(issuer, account, secret string) string {
+	// build the query
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	// build the uri, the label is issuer:account
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	// return the uri
+	return uri.String()
+}
+
+// Step is a function that returns the time step of a time, the number of periods since the unix epoch
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code is a function that returns the code of a secret at a time step
+func Code(secret string, step int64) (string, error) {
+	// decode the secret, apps show it in groups and lower case so both are accepted
+	key, err := encoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+
+	// compute the hmac of the step (RFC 4226 section 5.3)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// truncate the hmac dynamically
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	// keep the last digits
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+
+	// return the code padded with zeros
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// Validate is a function that checks a code at a time, allowing skew steps before and after
+// to tolerate clock drift. It returns the matched step so the caller can reject a code that is reused.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	// normalize the code
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	// check every step of the window
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		expected, err := Code(secret, current+int64(i))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + int64(i), true
+		}
+	}
+
+	// return false if no step matched
+	return 0, false
+}
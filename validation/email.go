@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// maxEmailLength is the longest address that fits in the forward-path of SMTP (RFC 5321 section 4.5.3.1.3)
+const maxEmailLength = 254
+
+// ErrInvalidEmail is returned when an email is not a valid address
+var ErrInvalidEmail = errors.New("invalid email")
+
+// NormalizeEmail is a function that validates an email address and returns it trimmed and in lower case.
+// Only a bare address is accepted, without display name or angle brackets.
+func NormalizeEmail(email string) (string, error) {
+	// remove the surrounding whitespace
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+
+	// parse the address (RFC 5322)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	// check that the domain has at least two labels, local domains can't receive our emails
+	at := strings.LastIndex(address.Address, "@")
+	domain := address.Address[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+
+	// return the address in lower case, so the same mailbox can't sign up twice
+	return strings.ToLower(address.Address), nil
+}
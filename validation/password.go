@@ -0,0 +1,139 @@
+package validation
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash, the rest would be ignored
+const maxPasswordBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordError is returned when a password breaks some rules of the policy
+type PasswordError struct {
+	Problems []string
+}
+
+// Error is a method that returns the broken rules
+func (e *PasswordError) Error() string {
+	return "invalid password: " + strings.Join(e.Problems, "; ")
+}
+
+// PasswordPolicy is the set of rules the new passwords must follow
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// blocklist is the set of common or breached passwords, in lower case
+	blocklist map[string]bool
+}
+
+// NewPasswordPolicy is a function that creates a policy that rejects the embedded common passwords
+// and the ones of the blocklist files, one password per line
+func NewPasswordPolicy(minLength int, blocklistFiles ...string) (*PasswordPolicy, error) {
+	// create the policy
+	policy := &PasswordPolicy{
+		MinLength: minLength,
+		blocklist: make(map[string]bool),
+	}
+
+	// add the embedded list
+	policy.addBlocklist(strings.NewReader(commonPasswords))
+
+	// add the files
+	for _, path := range blocklistFiles {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening password blocklist: %v", err)
+		}
+		err = policy.addBlocklist(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading password blocklist %s: %v", path, err)
+		}
+	}
+
+	// return the policy
+	return policy, nil
+}
+
+// addBlocklist is a method that adds the passwords of a list, one per line
+func (p *PasswordPolicy) addBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" && !strings.HasPrefix(password, "#") {
+			p.blocklist[strings.ToLower(password)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Validate is a method that checks a password against the policy.
+// It returns a *PasswordError with every broken rule, the email is used to reject passwords based on it.
+func (p *PasswordPolicy) Validate(password, email string) error {
+	// define the broken rules
+	var problems []string
+
+	// check the length, counted in characters
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("it must have at least %d characters", p.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("it must have at most %d bytes", maxPasswordBytes))
+	}
+
+	// check the character classes
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "it must have an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "it must have a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "it must have a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "it must have a symbol")
+	}
+
+	// check the common passwords and the email
+	normalized := strings.ToLower(password)
+	if p.blocklist[normalized] {
+		problems = append(problems, "it is too common")
+	}
+	if email = strings.ToLower(email); email != "" {
+		if local, _, _ := strings.Cut(email, "@"); normalized == email || normalized == local {
+			problems = append(problems, "it must not be the email")
+		}
+	}
+
+	// return the problems
+	if len(problems) > 0 {
+		return &PasswordError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDisplayNameLength is the longest display name, in characters
+const maxDisplayNameLength = 100
+
+// maxLocaleLength is the longest locale, the size of its column
+const maxLocaleLength = 35
+
+// Errors of the profile fields
+var (
+	ErrInvalidDisplayName = errors.New("invalid display name")
+	ErrInvalidLocale      = errors.New("invalid locale")
+	ErrInvalidTimezone    = errors.New("invalid timezone")
+)
+
+// localePattern matches a BCP 47 language tag like es, es-CO or zh-Hant-TW
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// NormalizeDisplayName is a function that validates a display name and returns it trimmed
+func NormalizeDisplayName(name string) (string, error) {
+	// remove the surrounding whitespace
+	name = strings.TrimSpace(name)
+
+	// check the length
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		return "", ErrInvalidDisplayName
+	}
+
+	// check that there are no control characters
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return "", ErrInvalidDisplayName
+		}
+	}
+
+	// return the name
+	return name, nil
+}
+
+// ValidateLocale is a function that checks that a locale is a language tag, empty clears it
+func ValidateLocale(locale string) error {
+	if len(locale) > maxLocaleLength || (locale != "" && !localePattern.MatchString(locale)) {
+		return ErrInvalidLocale
+	}
+	return nil
+}
+
+// ValidateTimezone is a function that checks that a timezone is an IANA name like America/Bogota, empty clears it
+func ValidateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
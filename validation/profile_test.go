@@ -0,0 +1,30 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   error
+	}{
+		{locale: ""},
+		{locale: "es"},
+		{locale: "es-CO"},
+		{locale: "zh-Hant-TW"},
+		{locale: "es-aaaaaaaa-bbbbbbbb-cccccccc-ddddd"},
+		{locale: "es-aaaaaaaa-bbbbbbbb-cccccccc-dddddd", want: ErrInvalidLocale},
+		{locale: "es-aaaaaaaa-bbbbbbbb-cccccccc-dddddddd", want: ErrInvalidLocale},
+		{locale: "e", want: ErrInvalidLocale},
+		{locale: "es_CO", want: ErrInvalidLocale},
+		{locale: "es-", want: ErrInvalidLocale},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateLocale(tt.locale); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateLocale(%q) = %v, want %v", tt.locale, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"platzi/go/rest-ws/models"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+
+	// pongWait is the time allowed to read the next pong message from the client
+	pongWait = 60 * time.Second
+
+	// pingPeriod is the period between pings, it must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// maxMessageSize is the maximum message size allowed from the client
+	maxMessageSize = 512
+
+	// sendBufferSize is the number of messages queued for a client before it is considered too slow
+	sendBufferSize = 256
+)
+
+// Authorizer is a function that checks if the credentials a client connected with are still valid.
+// It returns an error when they can't be checked, the client is kept connected then.
+type Authorizer func(ctx context.Context) (bool, error)
+
+// Client is a websocket connection registered in the hub
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	send   chan []byte
+	userId string
+
+	// scopes are the scopes granted to the token the client connected with
+	scopes []string
+
+	// topics is the set of patterns the client is subscribed to, it is owned by the hub loop
+	topics map[string]bool
+
+	// expiresAt is the expiration of the token used to connect, zero if it never expires
+	expiresAt time.Time
+
+	// authorize checks the credentials of the client on every ping, nil if they are never checked again
+	authorize Authorizer
+
+	// closeCode and closeReason are sent to the client when the send channel is closed,
+	// they are set by the hub before closing the channel
+	closeCode   int
+	closeReason string
+}
+
+// NewClient is a function that creates a new client of the user of the claims for the given connection
+func NewClient(hub *Hub, conn *websocket.Conn, claims *models.AppClaims) *Client {
+	return &Client{
+		hub:       hub,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+		userId:    claims.UserId,
+		scopes:    models.ParseScope(claims.Scope),
+		topics:    make(map[string]bool),
+		closeCode: websocket.CloseNormalClosure,
+	}
+}
+
+// UserId returns the id of the user that owns the connection
+func (c *Client) UserId() string {
+	return c.userId
+}
+
+// authorized is a method that checks if the credentials of the client are still valid
+func (c *Client) authorized() bool {
+	// check if the credentials are checked again
+	if c.authorize == nil {
+		return true
+	}
+
+	// check the credentials, bounded so a slow check doesn't stall the pings
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+	valid, err := c.authorize(ctx)
+	if err != nil {
+		// keep the client, the credentials are checked again on the next ping
+		log.Println("error checking websocket client:", err)
+		return true
+	}
+
+	// return if the credentials are valid
+	return valid
+}
+
+// hasScope is a method that checks if the token of the client was granted a scope
+func (c *Client) hasScope(scope string) bool {
+	for _, granted := range c.scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// readPump reads the subscription requests from the connection until it fails, keeping the read
+// deadline alive with the pong messages. It unregisters the client when it returns.
+func (c *Client) readPump() {
+	// unregister the client and close the connection when the pump ends
+	defer func() {
+		c.hub.unregisterClient(c)
+		c.conn.Close()
+	}()
+
+	// configure the connection limits
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	// read until the connection fails
+	for {
+		// read the next message
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("websocket read error:", err)
+			}
+			return
+		}
+
+		// decode the request, the hub answers with an error ack if it has no known type
+		var request Request
+		if err := json.Unmarshal(message, &request); err != nil {
+			request = Request{}
+		}
+
+		// hand the request to the hub
+		if !c.hub.send(command{client: c, request: request}) {
+			return
+		}
+	}
+}
+
+// writePump writes the queued messages and the pings to the connection.
+// It closes the connection when the send channel is closed by the hub, when the token expires or when
+// the credentials are no longer valid.
+func (c *Client) writePump() {
+	// define a ticker to ping the client
+	ticker := time.NewTicker(pingPeriod)
+
+	// define a channel that fires when the token expires, it is nil if the token never expires
+	var expired <-chan time.Time
+	if !c.expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(c.expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// stop the ticker and close the connection when the pump ends
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			// the hub closed the channel, say goodbye to the client
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+				return
+			}
+
+			// write the message
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// the token or the api key may have been revoked since the client connected
+			if !c.authorized() {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token revoked"))
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			// ping the client to keep the connection alive
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-expired:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			// the token is no longer valid, the read pump unregisters the client once the connection is closed
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"))
+			return
+		}
+	}
+}
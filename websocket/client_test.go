@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestClientAuthorized(t *testing.T) {
+	tests := []struct {
+		name      string
+		authorize Authorizer
+		want      bool
+	}{
+		{name: "never checked", want: true},
+		{name: "valid", authorize: func(ctx context.Context) (bool, error) { return true, nil }, want: true},
+		{name: "revoked", authorize: func(ctx context.Context) (bool, error) { return false, nil }},
+		{name: "check failed", authorize: func(ctx context.Context) (bool, error) { return false, errors.New("database down") }, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{authorize: tt.authorize}
+			if got := client.authorized(); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package websocket
+
+// Event types broadcasted by the handlers
+const (
+	CategoryCreated = "category.created"
+	CategoryUpdated = "category.updated"
+	CategoryDeleted = "category.deleted"
+)
+
+// Event is the JSON message sent to the clients subscribed to its topic
+type Event struct {
+	Type    string      `json:"type"`
+	Topic   string      `json:"topic"`
+	Payload interface{} `json:"payload"`
+}
+
+// NewEvent is a function that creates a new event of the given type
+func NewEvent(eventType string, payload interface{}) Event {
+	return Event{
+		Type:    eventType,
+		Payload: payload,
+	}
+}
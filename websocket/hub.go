@@ -0,0 +1,285 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"platzi/go/rest-ws/models"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrader upgrades the http connections to websocket connections
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// browsers send the token after this subprotocol, it must be echoed back (see middlewares.WebSocketTokenProtocol)
+	Subprotocols: []string{"access_token"},
+	// the api allows every origin, see the cors configuration of the server
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Hub keeps track of the connected clients and delivers the events to the clients subscribed to their topic
+type Hub struct {
+	clients       map[*Client]bool
+	subscriptions map[string]map[*Client]bool
+	register      chan *Client
+	unregister    chan *Client
+	commands      chan command
+	publications  chan publication
+	done          chan struct{}
+	stopped       chan struct{}
+	once          sync.Once
+}
+
+// NewHub is a function that creates a new hub
+func NewHub() *Hub {
+	return &Hub{
+		clients:       make(map[*Client]bool),
+		subscriptions: make(map[string]map[*Client]bool),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		commands:      make(chan command),
+		publications:  make(chan publication),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// Run is the hub loop, it must run in its own goroutine until Shutdown is called
+func (h *Hub) Run() {
+	// signal the shutdown that the loop has finished
+	defer close(h.stopped)
+
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+		case client := <-h.unregister:
+			h.removeClient(client, websocket.CloseNormalClosure, "")
+		case cmd := <-h.commands:
+			h.apply(cmd)
+		case pub := <-h.publications:
+			for client := range h.subscribers(pub.topic) {
+				h.deliver(client, pub.message)
+			}
+		case <-h.done:
+			for client := range h.clients {
+				h.removeClient(client, websocket.CloseGoingAway, "server shutting down")
+			}
+			return
+		}
+	}
+}
+
+// removeClient closes the send channel of a registered client, it must only be called from Run
+func (h *Hub) removeClient(client *Client, code int, reason string) {
+	// check if the client is still registered
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	// remove the subscriptions of the client
+	for pattern := range client.topics {
+		h.removeSubscription(client, pattern)
+	}
+
+	// close the client with the given code
+	delete(h.clients, client)
+	client.closeCode = code
+	client.closeReason = reason
+	close(client.send)
+}
+
+// deliver queues a message for a client, it must only be called from Run
+func (h *Hub) deliver(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		// the client is not keeping up, drop it instead of blocking everyone
+		h.removeClient(client, websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// subscribers returns the clients subscribed to a pattern matching the topic, it must only be called from Run
+func (h *Hub) subscribers(topic string) map[*Client]bool {
+	// define the set of clients, a client subscribed to several matching patterns receives the event once
+	clients := make(map[*Client]bool)
+
+	// collect the clients of every matching pattern
+	for pattern, subscribed := range h.subscriptions {
+		if !matchTopic(pattern, topic) {
+			continue
+		}
+		for client := range subscribed {
+			clients[client] = true
+		}
+	}
+
+	// return the clients
+	return clients
+}
+
+// apply applies the request of a client and acknowledges it, it must only be called from Run
+func (h *Hub) apply(cmd command) {
+	// ignore the requests of clients that are already gone
+	if _, ok := h.clients[cmd.client]; !ok {
+		return
+	}
+
+	// define the acknowledgement of the request
+	ack := Ack{
+		Id:    cmd.request.Id,
+		Topic: cmd.request.Topic,
+	}
+
+	// apply the request
+	switch cmd.request.Type {
+	case Subscribe:
+		if err := authorizeTopic(cmd.client, cmd.request.Topic); err != nil {
+			ack.Type, ack.Error = Error, err.Error()
+			break
+		}
+		h.addSubscription(cmd.client, cmd.request.Topic)
+		ack.Type = Subscribed
+	case Unsubscribe:
+		h.removeSubscription(cmd.client, cmd.request.Topic)
+		ack.Type = Unsubscribed
+	default:
+		ack.Type, ack.Error = Error, "unknown request type"
+	}
+
+	// encode the acknowledgement
+	message, err := json.Marshal(ack)
+	if err != nil {
+		log.Println("error encoding websocket ack:", err)
+		return
+	}
+
+	// send the acknowledgement
+	h.deliver(cmd.client, message)
+}
+
+// addSubscription adds a client to the index of a pattern, it must only be called from Run
+func (h *Hub) addSubscription(client *Client, pattern string) {
+	// create the set of the pattern if needed
+	if _, ok := h.subscriptions[pattern]; !ok {
+		h.subscriptions[pattern] = make(map[*Client]bool)
+	}
+
+	// index the client
+	h.subscriptions[pattern][client] = true
+	client.topics[pattern] = true
+}
+
+// removeSubscription removes a client from the index of a pattern, it must only be called from Run
+func (h *Hub) removeSubscription(client *Client, pattern string) {
+	// remove the client from the pattern
+	delete(h.subscriptions[pattern], client)
+	delete(client.topics, pattern)
+
+	// drop the pattern once nobody is subscribed
+	if len(h.subscriptions[pattern]) == 0 {
+		delete(h.subscriptions, pattern)
+	}
+}
+
+// registerClient adds a client to the hub, it returns false if the hub is stopped
+func (h *Hub) registerClient(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// unregisterClient removes a client from the hub
+func (h *Hub) unregisterClient(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// HandleWebSocket upgrades the request to a websocket connection and registers the client
+// of the user identified by the claims. The connection is closed when the claims expire or
+// when the authorizer finds them revoked.
+func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, claims *models.AppClaims, authorize Authorizer) {
+	// upgrade the connection, the upgrader already responds with an error if it fails
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("websocket upgrade error:", err)
+		return
+	}
+
+	// create the client of the user
+	client := NewClient(h, conn, claims)
+	client.authorize = authorize
+
+	// the session ends when the token expires
+	if claims.ExpiresAt != 0 {
+		client.expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	// register the client
+	if !h.registerClient(client) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
+
+	// start the pumps
+	go client.writePump()
+	go client.readPump()
+}
+
+// send queues the request of a client, it returns false if the hub is stopped
+func (h *Hub) send(cmd command) bool {
+	select {
+	case h.commands <- cmd:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Publish sends an event to every client subscribed to a pattern matching the topic
+func (h *Hub) Publish(topic string, event Event) error {
+	// set the topic of the event
+	event.Topic = topic
+
+	// encode the event
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event at Publish: %v", err)
+	}
+
+	// queue the publication unless the hub is stopped
+	select {
+	case h.publications <- publication{topic: topic, message: message}:
+		return nil
+	case <-h.done:
+		return fmt.Errorf("error publishing event %s: hub is stopped", event.Type)
+	}
+}
+
+// Shutdown closes every client connection and stops the hub loop
+func (h *Hub) Shutdown(ctx context.Context) error {
+	// stop the loop only once
+	h.once.Do(func() {
+		close(h.done)
+	})
+
+	// wait for the loop to close the clients
+	select {
+	case <-h.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping websocket hub: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"platzi/go/rest-ws/models"
+	"testing"
+	"time"
+)
+
+// receive is a function that decodes the next message queued for a client
+func receive(t *testing.T, client *Client, v interface{}) {
+	t.Helper()
+	select {
+	case message, ok := <-client.send:
+		if !ok {
+			t.Fatal("the client was closed")
+		}
+		if err := json.Unmarshal(message, v); err != nil {
+			t.Fatalf("error decoding message: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message was queued for the client")
+	}
+}
+
+// expectNothing is a function that checks that no message is queued for a client
+func expectNothing(t *testing.T, client *Client) {
+	t.Helper()
+	select {
+	case message := <-client.send:
+		t.Fatalf("unexpected message %s", message)
+	default:
+	}
+}
+
+// request is a function that sends the request of a client to the hub and returns its acknowledgement
+func request(t *testing.T, hub *Hub, client *Client, req Request) Ack {
+	t.Helper()
+	if !hub.send(command{client: client, request: req}) {
+		t.Fatal("the hub is stopped")
+	}
+	var ack Ack
+	receive(t, client, &ack)
+	return ack
+}
+
+func TestHubDeliversTheEventsOfTheSubscribedTopics(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	// register a reader and a user without the categories scope
+	reader := NewClient(hub, nil, &models.AppClaims{UserId: "reader", Scope: models.ScopeCategoriesRead})
+	other := NewClient(hub, nil, &models.AppClaims{UserId: "other"})
+	if !hub.registerClient(reader) || !hub.registerClient(other) {
+		t.Fatal("the hub is stopped")
+	}
+
+	// the reader subscribes to one category and to every category by its wildcard
+	for _, topic := range []string{CategoryTopic(1), "categories:*"} {
+		if ack := request(t, hub, reader, Request{Type: Subscribe, Id: topic, Topic: topic}); ack.Type != Subscribed || ack.Id != topic {
+			t.Fatalf("got ack %+v subscribing to %s", ack, topic)
+		}
+	}
+
+	// the other user can't subscribe to the categories, only to its own topic
+	if ack := request(t, hub, other, Request{Type: Subscribe, Topic: "categories:*"}); ack.Type != Error || ack.Error != ErrForbiddenTopic.Error() {
+		t.Fatalf("got ack %+v, want %s", ack, ErrForbiddenTopic)
+	}
+	if ack := request(t, hub, other, Request{Type: Subscribe, Topic: UserTopic("other")}); ack.Type != Subscribed {
+		t.Fatalf("got ack %+v subscribing to the topic of the user", ack)
+	}
+
+	// an event of a category reaches the reader once, even if two of its patterns match
+	if err := hub.Publish(CategoryTopic(1), NewEvent(CategoryUpdated, map[string]int{"id": 1})); err != nil {
+		t.Fatalf("error publishing event: %v", err)
+	}
+	var event Event
+	receive(t, reader, &event)
+	if event.Type != CategoryUpdated || event.Topic != CategoryTopic(1) {
+		t.Errorf("got event %+v", event)
+	}
+
+	// an event of the user only reaches the user, the publication is processed after the other one
+	if err := hub.Publish(UserTopic("other"), NewEvent("user.updated", nil)); err != nil {
+		t.Fatalf("error publishing event: %v", err)
+	}
+	receive(t, other, &event)
+	if event.Topic != UserTopic("other") {
+		t.Errorf("got event %+v", event)
+	}
+	expectNothing(t, reader)
+
+	// once unsubscribed from the wildcard, the reader only receives the events of its category.
+	// The hub handles the publications and the requests in order, so the ack follows any delivered event.
+	if ack := request(t, hub, reader, Request{Type: Unsubscribe, Topic: "categories:*"}); ack.Type != Unsubscribed {
+		t.Fatalf("got ack %+v unsubscribing", ack)
+	}
+	if err := hub.Publish(CategoryTopic(2), NewEvent(CategoryDeleted, nil)); err != nil {
+		t.Fatalf("error publishing event: %v", err)
+	}
+	if ack := request(t, hub, reader, Request{Type: "unknown"}); ack.Type != Error || ack.Error == "" {
+		t.Fatalf("got ack %+v for an unknown request", ack)
+	}
+	if err := hub.Publish(CategoryTopic(1), NewEvent(CategoryDeleted, nil)); err != nil {
+		t.Fatalf("error publishing event: %v", err)
+	}
+	receive(t, reader, &event)
+	if event.Type != CategoryDeleted || event.Topic != CategoryTopic(1) {
+		t.Errorf("got event %+v", event)
+	}
+}
+
+func TestHubShutdownClosesTheClients(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	// register a client
+	client := NewClient(hub, nil, &models.AppClaims{UserId: "user"})
+	if !hub.registerClient(client) {
+		t.Fatal("the hub is stopped")
+	}
+
+	// stop the hub
+	if err := hub.Shutdown(context.Background()); err != nil {
+		t.Fatalf("error stopping hub: %v", err)
+	}
+
+	// the client is closed as going away and nothing is accepted anymore
+	if _, ok := <-client.send; ok {
+		t.Fatal("the send channel of the client is still open")
+	}
+	if client.closeReason != "server shutting down" {
+		t.Errorf("got close reason %q", client.closeReason)
+	}
+	if hub.registerClient(NewClient(hub, nil, &models.AppClaims{UserId: "late"})) {
+		t.Error("registered a client after the shutdown")
+	}
+	if err := hub.Publish(CategoriesTopic, NewEvent(CategoryCreated, nil)); err == nil {
+		t.Error("published an event after the shutdown")
+	}
+}
@@ -0,0 +1,42 @@
+package websocket
+
+// Frame types sent by the clients
+const (
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+)
+
+// Frame types sent to the clients to acknowledge a request
+const (
+	Subscribed   = "subscribed"
+	Unsubscribed = "unsubscribed"
+	Error        = "error"
+)
+
+// Request is the JSON frame sent by a client to manage its subscriptions
+type Request struct {
+	Type  string `json:"type"`
+	Id    string `json:"id,omitempty"`
+	Topic string `json:"topic"`
+}
+
+// Ack is the JSON frame sent to a client once its request has been applied.
+// After a subscribed ack the client receives every event published on the topic.
+type Ack struct {
+	Type  string `json:"type"`
+	Id    string `json:"id,omitempty"`
+	Topic string `json:"topic"`
+	Error string `json:"error,omitempty"`
+}
+
+// command is a request of a client waiting to be applied by the hub
+type command struct {
+	client  *Client
+	request Request
+}
+
+// publication is an encoded event waiting to be delivered by the hub
+type publication struct {
+	topic   string
+	message []byte
+}
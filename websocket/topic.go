@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"errors"
+	"platzi/go/rest-ws/models"
+	"strconv"
+	"strings"
+)
+
+// Topic segments are separated by topicSeparator, a topicWildcard segment in a subscription
+// matches any single segment
+const (
+	topicSeparator = ":"
+	topicWildcard  = "*"
+)
+
+// Errors returned when a client can't subscribe to a topic
+var (
+	ErrInvalidTopic   = errors.New("invalid topic")
+	ErrUnknownTopic   = errors.New("unknown topic")
+	ErrForbiddenTopic = errors.New("forbidden topic")
+)
+
+// topicRules is a map of the root of a topic to the function that authorizes a client on it
+var topicRules = map[string]func(c *Client, segments []string) error{
+	// categories and categories:{id} are available to the tokens that may read the categories, like their routes
+	"categories": func(c *Client, segments []string) error {
+		if !c.hasScope(models.ScopeCategoriesRead) {
+			return ErrForbiddenTopic
+		}
+		return nil
+	},
+	// user:{id} is only available to the user itself
+	"user": func(c *Client, segments []string) error {
+		if len(segments) < 2 || segments[1] != c.userId {
+			return ErrForbiddenTopic
+		}
+		return nil
+	},
+}
+
+// CategoriesTopic is the topic of the events of every category
+const CategoriesTopic = "categories"
+
+// CategoryTopic is a function that returns the topic of the events of a category
+func CategoryTopic(id int64) string {
+	return CategoriesTopic + topicSeparator + strconv.FormatInt(id, 10)
+}
+
+// UserTopic is a function that returns the topic of the events of a user
+func UserTopic(id string) string {
+	return "user" + topicSeparator + id
+}
+
+// splitTopic is a function that splits a topic into its segments, it fails if a segment is empty
+func splitTopic(topic string) ([]string, error) {
+	// split the topic
+	segments := strings.Split(topic, topicSeparator)
+
+	// check that every segment has a value
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, ErrInvalidTopic
+		}
+	}
+
+	// return the segments
+	return segments, nil
+}
+
+// authorizeTopic is a function that checks if a client may subscribe to a topic pattern
+func authorizeTopic(c *Client, pattern string) error {
+	// split the pattern
+	segments, err := splitTopic(pattern)
+	if err != nil {
+		return err
+	}
+
+	// a wildcard root could match the topics of any user
+	if segments[0] == topicWildcard {
+		return ErrForbiddenTopic
+	}
+
+	// get the rule of the root
+	rule, ok := topicRules[segments[0]]
+	if !ok {
+		return ErrUnknownTopic
+	}
+
+	// apply the rule
+	return rule(c, segments)
+}
+
+// matchTopic is a function that checks if a topic matches a subscription pattern.
+// A pattern also matches the topics nested under it, so categories matches categories:12.
+func matchTopic(pattern, topic string) bool {
+	// split both topics
+	patternSegments := strings.Split(pattern, topicSeparator)
+	topicSegments := strings.Split(topic, topicSeparator)
+
+	// the pattern can't be more specific than the topic
+	if len(patternSegments) > len(topicSegments) {
+		return false
+	}
+
+	// compare every segment of the pattern
+	for i, segment := range patternSegments {
+		if segment != topicWildcard && segment != topicSegments[i] {
+			return false
+		}
+	}
+
+	// return true if every segment matched
+	return true
+}
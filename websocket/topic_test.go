@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"errors"
+	"platzi/go/rest-ws/models"
+	"testing"
+)
+
+func TestAuthorizeTopic(t *testing.T) {
+	reader := &Client{userId: "user-1", scopes: []string{models.ScopeCategoriesRead}}
+	noScope := &Client{userId: "user-1"}
+
+	tests := []struct {
+		name    string
+		client  *Client
+		pattern string
+		want    error
+	}{
+		{name: "categories", client: reader, pattern: "categories"},
+		{name: "category", client: reader, pattern: "categories:12"},
+		{name: "every category", client: reader, pattern: "categories:*"},
+		{name: "categories without scope", client: noScope, pattern: "categories", want: ErrForbiddenTopic},
+		{name: "category without scope", client: noScope, pattern: "categories:12", want: ErrForbiddenTopic},
+		{name: "own user", client: noScope, pattern: "user:user-1"},
+		{name: "own user nested", client: noScope, pattern: "user:user-1:sessions"},
+		{name: "other user", client: reader, pattern: "user:user-2", want: ErrForbiddenTopic},
+		{name: "every user", client: reader, pattern: "user:*", want: ErrForbiddenTopic},
+		{name: "user without id", client: reader, pattern: "user", want: ErrForbiddenTopic},
+		{name: "wildcard root", client: reader, pattern: "*:user-1", want: ErrForbiddenTopic},
+		{name: "unknown root", client: reader, pattern: "audit", want: ErrUnknownTopic},
+		{name: "empty segment", client: reader, pattern: "categories::12", want: ErrInvalidTopic},
+		{name: "empty", client: reader, pattern: "", want: ErrInvalidTopic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := authorizeTopic(tt.client, tt.pattern); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "categories", topic: "categories", want: true},
+		{pattern: "categories", topic: "categories:12", want: true},
+		{pattern: "categories:*", topic: "categories:12", want: true},
+		{pattern: "categories:12", topic: "categories:13"},
+		{pattern: "categories:12", topic: "categories"},
+		{pattern: "user:user-1", topic: "user:user-2"},
+	}
+
+	for _, tt := range tests {
+		if got := matchTopic(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}